@@ -0,0 +1,92 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Formats of the alert webhook payload.
+const (
+	alertGeneric = "generic" // the record as a JSON object
+	alertSlack   = "slack"   // a Slack or Teams incoming webhook message
+)
+
+// alerter posts records matching a filter to a webhook.
+type alerter struct {
+	filter expr
+	url    string
+	format string
+
+	client *http.Client
+	wg     sync.WaitGroup
+	sem    chan struct{} // limits the alerts sent at once
+}
+
+// newAlerter returns an alerter posting records matching filter to url
+// with the payload format.
+func newAlerter(filter expr, url, format string) *alerter {
+	return &alerter{
+		filter: filter,
+		url:    url,
+		format: format,
+		client: &http.Client{Timeout: 10 * time.Second},
+		sem:    make(chan struct{}, 4),
+	}
+}
+
+// payload returns the webhook payload for the record e.
+func (a *alerter) payload(e env) ([]byte, error) {
+	if a.format == alertSlack {
+		text := fmt.Sprintf("iplookupdb alert: %v%s", e["ip"],
+			annotation(" in {city}, {subdivision}, {country} ({country_iso})", e))
+		return json.Marshal(map[string]string{"text": text})
+	}
+	return json.Marshal(e)
+}
+
+// alert posts the record e to the webhook in the background if it matches
+// the filter. Errors are reported.
+func (a *alerter) alert(e env) {
+	v, err := a.filter.eval(e)
+	if err != nil || !truth(v) {
+		return
+	}
+
+	body, err := a.payload(e)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error alerting for IP %v: %v\n", e["ip"], err)
+		return
+	}
+
+	a.wg.Add(1)
+	a.sem <- struct{}{}
+	go func() {
+		defer func() {
+			<-a.sem
+			a.wg.Done()
+		}()
+
+		resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error alerting for IP %v: %v\n", e["ip"], err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			fmt.Fprintf(os.Stderr, "Error alerting for IP %v: webhook returned %s\n", e["ip"], resp.Status)
+		}
+	}()
+}
+
+// wait waits for the alerts being sent.
+func (a *alerter) wait() {
+	a.wg.Wait()
+}
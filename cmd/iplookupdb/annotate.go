@@ -0,0 +1,167 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// defaultAnnotateFormat is the default summary appended by -annotate.
+const defaultAnnotateFormat = " [{country_iso}/{subdivision}/{city}]"
+
+// isIPChar reports whether r can be part of an IP address, with an optional
+// port, as written in a log line.
+func isIPChar(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F' ||
+		r == '.' || r == ':'
+}
+
+// findIPs returns the IP addresses found in line, such as a log line, in the
+// order they appear. Ports are ignored, and unspecified addresses, such as ::,
+// are not returned since they are usually not addresses in text.
+func findIPs(line string) []netip.Addr {
+	var addrs []netip.Addr
+
+	for _, token := range strings.FieldsFunc(line, func(r rune) bool { return !isIPChar(r) }) {
+		token = strings.TrimRight(token, ".:")
+		if !strings.ContainsAny(token, ".:") {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(token)
+		if err != nil {
+			addrPort, err := netip.ParseAddrPort(token)
+			if err != nil {
+				continue
+			}
+			addr = addrPort.Addr()
+		}
+
+		if !addr.IsUnspecified() {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// annotation returns the summary of the record e based on format. The
+// placeholders {ip}, {city}, {subdivision}, {country}, and {country_iso}, or
+// the name of any other field of e, such as a computed column, are replaced
+// with their value, or unknown if the value is empty.
+func annotation(format string, e env) string {
+	var oldnew []string
+	for k, v := range e {
+		s := formatValue(v)
+		if s == "" {
+			s = "unknown"
+		}
+		oldnew = append(oldnew, "{"+k+"}", s)
+	}
+	return strings.NewReplacer(oldnew...).Replace(format)
+}
+
+// annotateLine writes line with a summary of the location of the first IP in
+// line appended, or of every IP with -extract, preserving the structure of
+// the line, such as a log line. If line does not contain an IP or the IPs
+// are skipped, line is written unchanged.
+//
+// If there is a filter, only lines where a record matches are written, with
+// the summaries of the matching records. Lines without an IP are matched
+// against an empty record.
+func (p *processor) annotateLine(line string) {
+	addrs := findIPs(line)
+	if !p.cfg.extract {
+		addrs = addrs[:min(len(addrs), 1)]
+	}
+
+	var records []env
+	for _, addr := range uniqueAddrs(addrs) {
+		if p.unique != nil {
+			p.unique[addr.Unmap()] = struct{}{}
+		}
+
+		loc, ok, err := p.locate(addr.Unmap())
+		if err != nil {
+			p.lookupErrors++
+			fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", addr.Unmap(), err)
+		} else if ok {
+			records = append(records, recordEnv(outputAddr(addr, p.cfg.keepMapped), "", loc))
+		}
+	}
+
+	if len(records) == 0 {
+		p.enrich(nil, func() { p.outputLine(line, nil) })
+		return
+	}
+
+	// records are emitted in order, so all are enriched once the last is
+	for n, e := range records {
+		emit := func() {}
+		if n == len(records)-1 {
+			emit = func() { p.outputLine(line, records) }
+		}
+		p.enrich(e, emit)
+	}
+}
+
+// extractLine looks up and outputs each IP found in line, such as a log line
+// or an email header, for -extract.
+func (p *processor) extractLine(line string) {
+	for _, addr := range uniqueAddrs(findIPs(line)) {
+		p.processAddr(addr, "")
+	}
+}
+
+// uniqueAddrs returns addrs without repeats, keeping the first occurrence.
+func uniqueAddrs(addrs []netip.Addr) []netip.Addr {
+	var unique []netip.Addr
+	for _, addr := range addrs {
+		if !slices.Contains(unique, addr) {
+			unique = append(unique, addr)
+		}
+	}
+	return unique
+}
+
+// outputLine writes line with the summaries of the records appended when
+// they match the filter. If there are no records, line is written unchanged
+// if an empty record matches the filter.
+func (p *processor) outputLine(line string, records []env) {
+	if len(records) == 0 {
+		if p.match(nil) {
+			p.writeLine(line)
+		}
+		return
+	}
+
+	matched := false
+	for _, e := range records {
+		if !p.runScript(e) {
+			continue
+		}
+		if _, ok := p.evalRecord(e); !ok {
+			continue
+		}
+		p.observe(e)
+		line += annotation(p.cfg.annotateFormat, e)
+		matched = true
+	}
+
+	if matched {
+		p.writeLine(line)
+	}
+}
+
+// writeLine writes line to the output as is.
+func (p *processor) writeLine(line string) {
+	p.written++
+	if _, err := fmt.Fprintln(p.out, line); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing output:", err)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// anonEnricher adds whether each IP is an anonymizer, such as a VPN or Tor
+// exit node, from an Anonymous-IP database, such as GeoIP2-Anonymous-IP, to
+// merge it with the location from the City db.
+type anonEnricher struct {
+	name string // of the database, opened by open
+	db   *geoip2.Reader
+}
+
+// newAnonEnricher returns an enricher using the Anonymous-IP database name.
+func newAnonEnricher(name string) *anonEnricher {
+	return &anonEnricher{name: name}
+}
+
+// open opens the Anonymous-IP database.
+func (x *anonEnricher) open() error {
+	db, err := geoip2.Open(x.name)
+	if err != nil {
+		return fmt.Errorf("-anon-db: %w", err)
+	}
+	if t := db.Metadata().DatabaseType; !strings.Contains(t, "Anonymous-IP") {
+		db.Close()
+		return fmt.Errorf("-anon-db: %s is a %s database, not an Anonymous-IP database", x.name, t)
+	}
+	x.db = db
+	return nil
+}
+
+func (x *anonEnricher) addedColumns() []string {
+	return []string{"is_anonymous", "is_vpn", "is_tor", "is_hosting", "is_proxy"}
+}
+
+// enrich adds the is_anonymous, is_vpn, is_tor, is_hosting, and is_proxy
+// fields to the record e, which are false if the IP is not in the
+// Anonymous-IP db. is_proxy is true for public and residential proxies.
+func (x *anonEnricher) enrich(e env) error {
+	for _, name := range x.addedColumns() {
+		e[name] = false
+	}
+
+	addr, err := netip.ParseAddr(formatValue(e["ip"]))
+	if err != nil {
+		return nil // changed by an earlier enricher
+	}
+	record, err := x.db.AnonymousIP(net.IP(addr.Unmap().AsSlice()))
+	if err != nil {
+		return err
+	}
+	e["is_anonymous"] = record.IsAnonymous
+	e["is_vpn"] = record.IsAnonymousVPN
+	e["is_tor"] = record.IsTorExitNode
+	e["is_hosting"] = record.IsHostingProvider
+	e["is_proxy"] = record.IsPublicProxy || record.IsResidentialProxy
+	return nil
+}
+
+// Close closes the Anonymous-IP database.
+func (x *anonEnricher) Close() error {
+	if x.db == nil {
+		return nil
+	}
+	return x.db.Close()
+}
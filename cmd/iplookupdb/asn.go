@@ -0,0 +1,70 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// asnEnricher adds the autonomous system of each IP from an ASN database,
+// such as GeoLite2-ASN, to merge it with the location from the City db.
+type asnEnricher struct {
+	name string // of the database, opened by open
+	db   *geoip2.Reader
+}
+
+// newASNEnricher returns an enricher using the ASN database name.
+func newASNEnricher(name string) *asnEnricher {
+	return &asnEnricher{name: name}
+}
+
+// open opens the ASN database.
+func (x *asnEnricher) open() error {
+	db, err := geoip2.Open(x.name)
+	if err != nil {
+		return fmt.Errorf("-asn-db: %w", err)
+	}
+	if t := db.Metadata().DatabaseType; !strings.Contains(t, "ASN") {
+		db.Close()
+		return fmt.Errorf("-asn-db: %s is a %s database, not an ASN database", x.name, t)
+	}
+	x.db = db
+	return nil
+}
+
+func (x *asnEnricher) addedColumns() []string {
+	return []string{"asn", "as_org"}
+}
+
+// enrich adds the asn and as_org fields to the record e, which are empty if
+// the IP is not in the ASN db.
+func (x *asnEnricher) enrich(e env) error {
+	e["asn"], e["as_org"] = "", ""
+
+	addr, err := netip.ParseAddr(formatValue(e["ip"]))
+	if err != nil {
+		return nil // changed by an earlier enricher
+	}
+	record, err := x.db.ASN(net.IP(addr.Unmap().AsSlice()))
+	if err != nil || record.AutonomousSystemNumber == 0 {
+		return err
+	}
+	e["asn"] = strconv.FormatUint(uint64(record.AutonomousSystemNumber), 10)
+	e["as_org"] = record.AutonomousSystemOrganization
+	return nil
+}
+
+// Close closes the ASN database.
+func (x *asnEnricher) Close() error {
+	if x.db == nil {
+		return nil
+	}
+	return x.db.Close()
+}
@@ -0,0 +1,156 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+// Keyring service and user for the stored MaxMind credentials.
+const (
+	keyringService = "iplookupdb"
+	keyringUser    = "maxmind"
+)
+
+// credentials are used to download databases from MaxMind.
+type credentials struct {
+	AccountID  string `json:"account_id"`
+	LicenseKey string `json:"license_key"`
+}
+
+// errNoCredentials is returned if no credentials are stored.
+var errNoCredentials = errors.New("no MaxMind credentials stored, use iplookupdb auth login")
+
+// saveCredentials stores c in the OS keyring, such as the macOS Keychain,
+// Windows Credential Manager, or the Secret Service on Linux.
+func saveCredentials(c credentials) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return keyring.Set(keyringService, keyringUser, string(b))
+}
+
+// loadCredentials returns the credentials stored in the OS keyring.
+func loadCredentials() (credentials, error) {
+	var c credentials
+
+	s, err := keyring.Get(keyringService, keyringUser)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return c, errNoCredentials
+	}
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal([]byte(s), &c)
+	return c, err
+}
+
+// deleteCredentials removes the credentials from the OS keyring.
+func deleteCredentials() error {
+	err := keyring.Delete(keyringService, keyringUser)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return errNoCredentials
+	}
+	return err
+}
+
+// runAuth runs the auth subcommand, which manages the MaxMind credentials
+// stored in the OS keyring with the login, logout, and status actions.
+func runAuth(args []string) int {
+	const usage = "usage: iplookupdb auth login [-account-id id] | logout | status"
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return exitUsage
+	}
+
+	var err error
+	switch args[0] {
+	case "login":
+		err = authLogin(args[1:])
+	case "logout":
+		err = deleteCredentials()
+		if err == nil {
+			fmt.Println("Removed MaxMind credentials")
+		}
+	case "status":
+		var c credentials
+		c, err = loadCredentials()
+		if err == nil {
+			fmt.Printf("MaxMind credentials stored for account %s\n", c.AccountID)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return exitUsage
+	}
+
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "auth %s: %v\n", args[0], err)
+		}
+		return exitUsage
+	}
+	return exitOK
+}
+
+// authLogin stores the MaxMind account ID and license key in the OS keyring.
+// The license key is read from stdin, without echo on a terminal, so it is
+// not exposed in the shell history or process list.
+func authLogin(args []string) error {
+	fs := flag.NewFlagSet("auth login", flag.ContinueOnError)
+	accountID := fs.String("account-id", "", "MaxMind account ID")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+
+	if *accountID == "" {
+		fmt.Fprint(os.Stderr, "MaxMind account ID: ")
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		*accountID = strings.TrimSpace(line)
+	}
+
+	fmt.Fprint(os.Stderr, "MaxMind license key: ")
+	var key string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		key = string(b)
+	} else {
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		key = line
+	}
+	key = strings.TrimSpace(key)
+
+	if *accountID == "" || key == "" {
+		return errors.New("account ID and license key are required")
+	}
+
+	if err := saveCredentials(credentials{*accountID, key}); err != nil {
+		return fmt.Errorf("cannot store credentials in OS keyring: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Stored MaxMind credentials for account %s\n", *accountID)
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// signatures identify common binary formats accidentally used as input.
+var signatures = []struct {
+	magic []byte
+	kind  string
+}{
+	{[]byte{0x1f, 0x8b}, "gzip compressed data; decompress it first"},
+	{[]byte("BZh"), "bzip2 compressed data; decompress it first"},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, "xz compressed data; decompress it first"},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd compressed data; decompress it first"},
+	{[]byte("PK\x03\x04"), "a zip archive; extract it first"},
+	{[]byte{0xd4, 0xc3, 0xb2, 0xa1}, "a pcap capture"},
+	{[]byte{0xa1, 0xb2, 0xc3, 0xd4}, "a pcap capture"},
+	{[]byte{0x0a, 0x0d, 0x0d, 0x0a}, "a pcapng capture"},
+	{[]byte{0xff, 0xfe}, "UTF-16 text; convert it to UTF-8 first"},
+	{[]byte{0xfe, 0xff}, "UTF-16 text; convert it to UTF-8 first"},
+}
+
+// binaryInputError is returned when the input is not text.
+type binaryInputError struct {
+	kind string
+}
+
+func (e *binaryInputError) Error() string {
+	return "input is not text, it appears to be " + e.kind
+}
+
+// binaryChecker is a reader that fails with a binaryInputError if the first
+// data read from r is a known binary format or is mostly control characters.
+// This avoids a flood of parse errors when a binary file is used as input.
+type binaryChecker struct {
+	r       io.Reader
+	checked bool
+}
+
+func (b *binaryChecker) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if b.checked || n == 0 {
+		return n, err
+	}
+	b.checked = true
+
+	for _, sig := range signatures {
+		if bytes.HasPrefix(p[:n], sig.magic) {
+			return 0, &binaryInputError{sig.kind}
+		}
+	}
+	if mostlyBinary(p[:n]) {
+		return 0, &binaryInputError{"binary data"}
+	}
+	return n, err
+}
+
+// mostlyBinary reports whether more than a tenth of b are NUL or other
+// control characters that do not occur in text.
+func mostlyBinary(b []byte) bool {
+	control := 0
+	for _, c := range b {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			control++
+		}
+	}
+	return control*10 > len(b)
+}
+
+// isBinary reports whether line contains NUL bytes or invalid UTF-8.
+func isBinary(line string) bool {
+	return strings.IndexByte(line, 0) >= 0 || !utf8.ValidString(line)
+}
+
+// sanitize returns line with invalid UTF-8 and control characters removed.
+func sanitize(line string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(line, ""))
+}
+
+// binaryRecord handles an input record containing binary data, such as
+// garbage in an otherwise text file. If clean is set, the sanitized line is
+// returned to be processed. Otherwise, ok is false and the record is skipped.
+// Only the first binary record is reported to avoid flooding stderr.
+func (p *processor) binaryRecord(line string) (sanitized string, ok bool) {
+	p.binary++
+	if p.binary == 1 {
+		action := "skipping"
+		if p.cfg.clean {
+			action = "sanitizing"
+		}
+		fmt.Fprintf(os.Stderr, "Input record %d contains binary data, %s it and any others\n",
+			p.records, action)
+	}
+
+	if p.cfg.clean {
+		return sanitize(line), true
+	}
+	return "", false
+}
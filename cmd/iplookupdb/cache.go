@@ -0,0 +1,74 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"net/netip"
+)
+
+// locationCache is the cache of locations used by the cache dupes policy.
+// If it has a size, the least recently used location is evicted when it is
+// full. It is not safe for concurrent use.
+type locationCache struct {
+	size    int // maximum entries, or zero for no limit
+	entries map[netip.Addr]*list.Element
+	order   list.List // of *cacheEntry, most recently used first
+}
+
+// cacheEntry is the location of an IP in a locationCache.
+type cacheEntry struct {
+	addr netip.Addr
+	c    cachedLocation
+}
+
+// newLocationCache returns an empty cache that holds at most size
+// locations, or any number if size is zero.
+func newLocationCache(size int) *locationCache {
+	return &locationCache{size: size, entries: make(map[netip.Addr]*list.Element)}
+}
+
+// get returns the location of addr, if cached, marking it as recently used.
+func (lc *locationCache) get(addr netip.Addr) (cachedLocation, bool) {
+	el, found := lc.entries[addr]
+	if !found {
+		return cachedLocation{}, false
+	}
+	lc.order.MoveToFront(el)
+	return el.Value.(*cacheEntry).c, true
+}
+
+// contains reports whether addr is cached without marking it as used.
+func (lc *locationCache) contains(addr netip.Addr) bool {
+	_, found := lc.entries[addr]
+	return found
+}
+
+// add caches the location c of addr, evicting the least recently used
+// location if the cache is full.
+func (lc *locationCache) add(addr netip.Addr, c cachedLocation) {
+	if el, found := lc.entries[addr]; found {
+		el.Value.(*cacheEntry).c = c
+		lc.order.MoveToFront(el)
+		return
+	}
+
+	lc.entries[addr] = lc.order.PushFront(&cacheEntry{addr, c})
+	if lc.size > 0 && lc.order.Len() > lc.size {
+		oldest := lc.order.Back()
+		lc.order.Remove(oldest)
+		delete(lc.entries, oldest.Value.(*cacheEntry).addr)
+	}
+}
+
+// len returns the number of cached locations.
+func (lc *locationCache) len() int {
+	return lc.order.Len()
+}
+
+// clear removes all cached locations.
+func (lc *locationCache) clear() {
+	clear(lc.entries)
+	lc.order.Init()
+}
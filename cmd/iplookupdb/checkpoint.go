@@ -0,0 +1,108 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpoint records how far a run progressed through its input so an
+// interrupted run can be resumed.
+type checkpoint struct {
+	Input   string    `json:"input"`   // input file name, empty for stdin
+	Offset  int64     `json:"offset"`  // bytes of input processed
+	Records int       `json:"records"` // records of input processed
+	Written int       `json:"written"` // rows written to the output
+	Done    bool      `json:"done"`    // true if all input was processed
+	Time    time.Time `json:"time"`
+}
+
+// readCheckpoint reads the checkpoint from the file name.
+func readCheckpoint(name string) (checkpoint, error) {
+	var cp checkpoint
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return cp, fmt.Errorf("%s: %w", name, err)
+	}
+	return cp, nil
+}
+
+// writeCheckpoint writes cp to the file name. The file is replaced atomically
+// so a crash while writing does not lose the previous checkpoint.
+func writeCheckpoint(name string, cp checkpoint) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// resume positions p to continue after the records in cp. If r can seek, it
+// is moved to the offset in cp. Otherwise, the processed records are read
+// and skipped.
+func (p *processor) resume(cp checkpoint, r io.Reader) error {
+	if cp.Input != p.cfg.inputName {
+		return fmt.Errorf("checkpoint is for input %q, not %q", cp.Input, p.cfg.inputName)
+	}
+
+	p.written = cp.Written
+
+	if s, ok := r.(io.Seeker); ok && cp.Input != "" {
+		if _, err := s.Seek(cp.Offset, io.SeekStart); err == nil {
+			p.records = cp.Records
+			p.offset = cp.Offset
+			return nil
+		}
+	}
+
+	p.resumeAfter = cp.Records
+	return nil
+}
+
+// saveCheckpoint writes a checkpoint for p if checkpoints are enabled and
+// either done is true or another checkpoint interval of records was read.
+func (p *processor) saveCheckpoint(done bool) {
+	if p.cfg.checkpoint == "" {
+		return
+	}
+	if !done && p.records%p.cfg.checkpointEvery != 0 {
+		return
+	}
+	p.drain()
+
+	cp := checkpoint{
+		Input:   p.cfg.inputName,
+		Offset:  p.offset,
+		Records: p.records,
+		Written: p.written,
+		Done:    done,
+		Time:    time.Now(),
+	}
+	if err := writeCheckpoint(p.cfg.checkpoint, cp); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write checkpoint: %v\n", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// command runs a subcommand with args and returns the exit status.
+type command func(args []string) int
+
+// commands are the subcommands selected by the first argument.
+var commands = map[string]command{
+	"auth":        runAuth,
+	"config":      runConfig,
+	"connections": runConnections,
+	"dbinfo":      runDBInfo,
+	"fail2ban":    runFail2ban,
+	"mrt":         runMRT,
+	"selftest":    runSelftest,
+	"sets":        runSets,
+	"sshreport":   runSSHReport,
+	"traceroute":  runTraceroute,
+	"update":      runUpdate,
+}
+
+// runConfig runs the config subcommand. The only action is check, which
+// resolves the effective settings from the flags in args, reports any
+// conflicts, and prints the merged configuration to stdout.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: iplookupdb config check [flags]")
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	if err := checkConfig(os.Stdout, fs, args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		}
+		return exitUsage
+	}
+	return exitOK
+}
+
+// checkConfig parses args with fs and writes each setting, its effective
+// value, and where the value came from to w. An error is returned if the
+// flags are unknown or conflict.
+func checkConfig(w io.Writer, fs *flag.FlagSet, args []string) error {
+	cfg, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	fs.VisitAll(func(f *flag.Flag) {
+		source := "default"
+		if s, found := cfg.sources[f.Name]; found {
+			source = s
+		} else if set[f.Name] {
+			source = "flag"
+		}
+		fmt.Fprintf(w, "%s=%q (%s)\n", f.Name, f.Value.String(), source)
+	})
+
+	if fs.NArg() > 0 {
+		fmt.Fprintf(w, "%d IPs on the command line\n", fs.NArg())
+	}
+	return nil
+}
@@ -0,0 +1,838 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// config contains the command-line flags.
+type config struct {
+	dbName        string       // the first of -db
+	fallbackNames []string     // the other -db, tried in order
+	fallbacks     []fallbackDB // opened fallbackNames, set by main
+	inputName     string
+	outputName    string
+	lang          string // comma-separated languages of names, in order
+	listLangs     bool   // list the languages of the db and exit
+	dbType        string // type of the db, see dbTypes
+	delimiter     rune
+
+	private      string // policy for private and other special IPs
+	privateLabel string // label used by the label policy, or the scope if empty
+	privateDB    string // internal sites db used by the internal policy
+
+	skipInvalid bool // silently skip inputs that are not valid IPs
+	strict      bool // stop at the first invalid input or failed lookup
+	keepMapped  bool // output IPv4-mapped IPv6 addresses as given
+	portColumn  bool // output the port of host:port inputs as a column
+	clean       bool // remove decorations such as quotes around inputs
+
+	dupes     string // policy for duplicate IPs
+	cacheSize int    // maximum locations cached by the cache policy, 0 for all
+	summary   string // group records by country, city, or asn and count them
+	dedupe    string // store of the IPs output, to output each once, or ""
+
+	encoding string   // encoding of the output
+	format   string   // format of the output
+	decimal  string   // decimal separator of coordinates and distances in CSV, if not .
+	fields   []string // fields output as columns, nil for the default
+	header   bool     // write a header row with the column names
+
+	sources map[string]string // source of flags set from the env or config file
+
+	template       *template.Template // writes each record, if not nil
+	templateFields []string           // fields available to template
+
+	skip int // number of input records to skip
+	max  int // maximum number of input records to process, 0 for all
+
+	maxAge   time.Duration // maximum age of the database, 0 for any
+	validate bool          // check the configuration without any lookups
+
+	verbose verbose // write diagnostics to stderr
+	latency bool    // output the lookup duration of each record
+
+	stats    bool   // write run statistics to stderr at exit
+	statsOut string // file to write run statistics to at exit
+
+	checkpoint string // file to record progress to
+
+	progress         bool          // write progress to stderr periodically
+	progressInterval time.Duration // between progress reports
+	checkpointEvery  int           // number of records between checkpoints
+	resume           bool          // continue from the checkpoint
+
+	deterministic bool // guarantee identical output for identical inputs
+
+	annotate       bool   // output input lines with a summary appended
+	annotateFormat string // format of the summary appended by annotate
+	extract        bool   // look up every IP found in each input line
+
+	events      bool   // read and write NDJSON events
+	eventIP     string // field of events containing the IP
+	eventTarget string // field of events the location is added to
+
+	csvIn    bool // read CSV rows and write each with columns appended
+	ipColumn int  // 1-based column of csvIn rows containing the IP
+
+	filter   expr             // only output records matching filter, nil for all
+	computed []computedColumn // columns computed from expressions
+
+	enrichers   []enricher        // stages run on each record, in order, opened by openEnrichers
+	distance    *distanceEnricher // adds the distance, also in enrichers, or nil
+	enrichLimit int               // maximum number of records enriched at once
+	workers     int               // number of records looked up at once
+
+	maxExpand       int     // maximum addresses a CIDR or range is expanded to
+	summarizeRanges bool    // output a record per group of addresses in a range
+	script          *script // script run on each record, nil for none
+
+	watchDir string // directory to watch for input files
+	serve    string // address to serve lookups over HTTP on
+	grpc     string // address to serve lookups over gRPC on
+
+	reloadInterval time.Duration // between checks for a new db file, 0 for none
+	follow         bool          // keep reading the input files as lines are added
+	reopen         bool          // reopen the input named pipe when writers close it
+
+	metricsAddr   string   // address to serve Prometheus metrics on
+	metricsLabels []string // fields used as labels of the metrics
+
+	alerter *alerter // posts matching records to a webhook, nil for none
+}
+
+// stringsFlag is a flag that may be repeated, collecting each value.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// parseFlags parses args using fs and does some simple validation of the
+// command-line flags.
+func parseFlags(fs *flag.FlagSet, args []string) (config, error) {
+	dbName := fs.String("db", "GeoLite2-City.mmdb", "Path to the GeoLite2 City database, or a comma-separated list of databases to try in order, e.g., GeoIP2-City.mmdb,GeoLite2-City.mmdb.")
+	inputFile := fs.String("in", "", "Input file path. If not specified, reads from stdin.")
+	outputFile := fs.String("out", "", "Output file path. If not specified, writes to stdout.")
+	lang := fs.String("lang", "en", "Language for GeoIP lookup results, or a comma-separated list to fall back through, e.g., ja,en.")
+	listLangs := fs.Bool("list-langs", false, "List the languages of the database and exit.")
+	dbType := fs.String("type", dbTypeAuto, "Type of the database: auto, city, country, asn, anonymous-ip, isp, or domain.")
+	delimiter := fs.String("delimiter", ",", "Delimiter for the CSV output.")
+	private := fs.String("private", privateLabel, "Handling of private and other special IPs, such as loopback: label, skip, or internal.")
+	privLabel := fs.String("private-label", "", "Label used for private and other special IPs with -private label. Defaults to the scope of the IP, e.g., private or loopback.")
+	privateDB := fs.String("private-db", "", "Internal sites MMDB or CSV used with -private internal.")
+	skipInvalid := fs.Bool("skip-invalid", false, "Silently skip inputs that are not valid IPs.")
+	strict := fs.Bool("strict", false, "Stop at the first invalid input or failed lookup.")
+	skipPrivate := fs.Bool("skip-private", false, "Omit private and other special IPs from the output. Same as -private skip.")
+	keepMapped := fs.Bool("keep-mapped", false, "Output IPv4-mapped IPv6 addresses in IPv6 form.")
+	fieldList := fs.String("fields", "", "Comma-separated fields to output, in order, e.g., ip,country_iso,latitude,longitude.")
+	header := fs.Bool("header", false, "Write a header row with the names of the columns.")
+	templateText := fs.String("template", "", "Go template used to write each record as a line, e.g., '{{.IP}} is in {{.City}}, {{.Country}}'.")
+	portColumn := fs.Bool("port-column", false, "Output the port of host:port inputs as a column after the IP.")
+	clean := fs.Bool("clean", false, "Remove quotes, brackets, and trailing punctuation around inputs.")
+	dupes := fs.String("dupes", dupesLookup, "Handling of duplicate IPs: lookup, cache, or collapse.")
+	cacheSize := fs.Int("cache-size", 0, "Maximum number of locations cached by -dupes cache, evicting the least recently used. 0 is no limit. Implies -dupes cache.")
+	unique := fs.Bool("unique", false, "Output only the first occurrence of each IP. Same as -dedupe memory.")
+	dedupe := fs.String("dedupe", "", "Output only the first occurrence of each IP, tracking the IPs output in memory or on disk, for inputs with too many unique IPs to fit in memory.")
+	summary := fs.String("summary", "", "Output the number of records for each country, city, or asn, most common first, instead of each record.")
+	format := fs.String("format", formatCSV, "Format of the output: csv, json, ndjson, misp, stix, or parquet.")
+	encoding := fs.String("encoding", encUTF8, "Encoding of the output: utf-8, utf-8-bom, or utf-16le.")
+	locale := fs.String("locale", "", "Locale, such as de or fr-CA, whose decimal separator is used for the coordinates and distances of the CSV output.")
+	skip := fs.Int("skip", 0, "Number of input records to skip before processing.")
+	maxRecords := fs.Int("max", 0, "Maximum number of input records to process. 0 processes all.")
+	maxAge := fs.Duration("max-age", 0, "Maximum age of the database, e.g., 720h. 0 allows any age.")
+	validate := fs.Bool("validate", false, "Check the database, inputs, and output without any lookups.")
+	latency := fs.Bool("latency", false, "Output the lookup duration of each record in microseconds.")
+	stats := fs.Bool("stats", false, "Write run statistics as JSON to stderr at exit.")
+	statsOut := fs.String("stats-out", "", "File to write run statistics as JSON to at exit.")
+	checkpoint := fs.String("checkpoint", "", "File to periodically record progress to for -resume.")
+	progress := fs.Bool("progress", false, "Write progress, such as records/sec and the ETA, to stderr periodically and a summary at exit.")
+	progressInterval := fs.Duration("progress-interval", 10*time.Second, "Interval between -progress reports.")
+	checkpointEvery := fs.Int("checkpoint-every", 10000, "Number of input records between checkpoints.")
+	resume := fs.Bool("resume", false, "Continue from the -checkpoint file, appending to the output.")
+	deterministic := fs.Bool("deterministic", false, "Guarantee byte-identical output for identical input and database.")
+	annotate := fs.Bool("annotate", false, "Output each input line with a summary of its first IP appended.")
+	annotateFormat := fs.String("annotate-format", defaultAnnotateFormat, "Format of the summary appended by -annotate.")
+	extract := fs.Bool("extract", false, "Look up every IP found in each input line, such as a log line, instead of one IP per line.")
+	events := fs.Bool("events", false, "Read NDJSON events and write each event with the location of its IP added.")
+	eventIP := fs.String("event-ip", defaultEventIP, "Field of -events containing the IP. Use dots for nested fields, e.g., source.ip.")
+	eventTarget := fs.String("event-target", defaultEventTarget, "Field added to -events with the location.")
+	csvIn := fs.Bool("csv-in", false, "Read CSV rows and write each row with the output columns for its IP appended.")
+	ipColumn := fs.Int("ip-column", 1, "Column of -csv-in rows containing the IP, starting at 1.")
+	filter := fs.String("filter", "", "Only output records matching the expression, e.g., 'country_iso==\"RU\"'.")
+	includeCountry := fs.String("include-country", "", "Comma-separated ISO country codes to output, e.g., US,CA.")
+	excludeCountry := fs.String("exclude-country", "", "Comma-separated ISO country codes not to output, e.g., CN,RU.")
+	includeContinent := fs.String("include-continent", "", "Comma-separated continent codes to output, e.g., EU,NA.")
+	excludeContinent := fs.String("exclude-continent", "", "Comma-separated continent codes not to output.")
+	includeASN := fs.String("include-asn", "", "Comma-separated ASNs to output, e.g., 13335,AS15169. Needs the asn field.")
+	excludeASN := fs.String("exclude-asn", "", "Comma-separated ASNs not to output. Needs the asn field.")
+	invert := fs.Bool("invert", false, "Output the records that do not match the -include and -exclude flags instead.")
+	var compute stringsFlag
+	fs.Var(&compute, "compute", "Add a column computed by an expression, e.g., 'risk=is_private ? 0 : 50'. May be repeated.")
+	var enrich stringsFlag
+	fs.Var(&enrich, "enrich", "Run the registered enricher on each record. May be repeated.")
+	asnDB := fs.String("asn-db", "", "ASN database, such as GeoLite2-ASN.mmdb, used to add the asn and as_org of each IP.")
+	anonDB := fs.String("anon-db", "", "Anonymous-IP database, such as GeoIP2-Anonymous-IP.mmdb, used to add is_anonymous, is_vpn, is_tor, is_hosting, and is_proxy.")
+	customDB := fs.String("custom-db", "", "Custom MMDB, such as an internal db of office and VPN networks, used to add the -custom-fields of each IP.")
+	customFields := fs.String("custom-fields", "", "Comma-separated keys of the -custom-db records to add as columns, e.g., site,vlan,owner. Nested keys are dotted paths, e.g., location.building.")
+	pfx2as := fs.String("pfx2as", "", "CAIDA pfx2as file or ASN MMDB used to add the announced prefix and origin AS of each IP.")
+	rdns := fs.Bool("rdns", false, "Add the hostname of each IP from a reverse DNS (PTR) lookup.")
+	rdnsTimeout := fs.Duration("rdns-timeout", defaultRDNSTimeout, "Maximum time to wait for each -rdns lookup.")
+	rdnsConcurrency := fs.Int("rdns-concurrency", defaultRDNSConcurrency, "Maximum number of -rdns lookups at once.")
+	from := fs.String("from", "", "Reference point as latitude,longitude used to add the distance_km of each IP, e.g., 51.5,-0.13.")
+	fromIP := fs.String("from-ip", "", "IP whose location is the reference point used to add the distance_km of each IP.")
+	units := fs.String("units", unitsKm, "Units of the distance from -from or -from-ip: km, added as distance_km, or mi, added as distance_mi.")
+	var hosting stringsFlag
+	fs.Var(&hosting, "hosting-ranges", "Hosting provider range list, as provider=file or file, used to add is_datacenter and provider. May be repeated.")
+	execEnrich := fs.String("exec-enrich", "", "External command that receives each record as JSON and returns JSON fields to merge.")
+	execColumns := fs.String("exec-columns", "", "Comma-separated fields added by -exec-enrich to output as columns.")
+	execConcurrency := fs.Int("exec-concurrency", 4, "Maximum number of records to enrich at once.")
+	maxExpand := fs.Int("max-expand", defaultMaxExpand, "Maximum number of addresses a CIDR or range input is expanded to.")
+	summarizeRanges := fs.Bool("summarize-ranges", false, "Output one record for consecutive addresses of a CIDR or range input with the same location.")
+	workers := fs.Int("workers", 1, "Number of IPs to look up at once. Output stays in input order.")
+	scriptName := fs.String("script", "", "Starlark script with a process(record) function to modify, enrich, or drop records.")
+	follow := fs.Bool("follow", false, "Keep reading the -in file, which may be a glob, as lines are added, like tail -F.")
+	reopen := fs.Bool("reopen", false, "Reopen the -in named pipe when its writers close it, instead of stopping.")
+	metricsAddr := fs.String("metrics-addr", "", "Address to serve Prometheus metrics of the output records on, e.g., :9100.")
+	metricsLabels := fs.String("metrics-labels", defaultMetricsLabels, "Comma-separated fields used as labels of the metrics.")
+	alertFilter := fs.String("alert-filter", "", "Post records matching the expression to the -alert-webhook.")
+	alertWebhook := fs.String("alert-webhook", "", "URL of the webhook to post records matching -alert-filter to.")
+	alertFormat := fs.String("alert-format", alertGeneric, "Format of the webhook payload: generic or slack, which also works for Teams.")
+	watchDir := fs.String("watch-dir", "", "Directory to watch for new input files to process.")
+	reloadInterval := fs.Duration("reload-interval", defaultReloadInterval, "Interval between checks for a new -db file in -serve, -grpc, -watch-dir, -follow, and -reopen modes. 0 only reloads on SIGHUP.")
+	serveAddr := fs.String("serve", "", "Address to serve lookups over HTTP on, e.g., :8080.")
+	grpcAddr := fs.String("grpc", "", "Address to serve lookups over gRPC on, e.g., :9090.")
+	verboseFlag := fs.Bool("verbose", false, "Write diagnostics, such as database metadata and timing, to stderr.")
+	configFile := fs.String("config", "", "Config file with default flag values. Defaults to iplookupdb/config.toml in the user config directory, if it exists.")
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	// flags not given take their value from the environment or config file
+	explicit := *configFile != ""
+	if !explicit {
+		*configFile = os.Getenv(envName("config"))
+		explicit = *configFile != ""
+	}
+	if !explicit {
+		*configFile = defaultConfigFile()
+	}
+	sources, err := applyDefaults(fs, *configFile, explicit)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid defaults: %w", err)
+	}
+	serving := *serveAddr != "" || *grpcAddr != ""
+
+	if fs.NArg() > 0 && *inputFile != "" {
+		return config{}, errors.New("cannot provide both -in and IPs on command line")
+	}
+
+	if len(*delimiter) != 1 {
+		return config{}, errors.New("must specify a single character as a delimiter")
+	}
+	delimRune := rune((*delimiter)[0])
+
+	if *skipPrivate {
+		if *private == privateInternal {
+			return config{}, errors.New("cannot use -skip-private with -private internal")
+		}
+		*private = privateSkip
+	}
+
+	switch *private {
+	case privateLabel, privateSkip:
+	case privateInternal:
+		if *privateDB == "" {
+			return config{}, errors.New("-private internal requires -private-db")
+		}
+	default:
+		return config{}, fmt.Errorf("unknown -private policy %q", *private)
+	}
+
+	switch *dupes {
+	case dupesLookup, dupesCache, dupesCollapse:
+	default:
+		return config{}, fmt.Errorf("unknown -dupes policy %q", *dupes)
+	}
+	switch {
+	case *cacheSize < 0:
+		return config{}, errors.New("-cache-size must not be negative")
+	case *cacheSize > 0 && *dupes == dupesCollapse:
+		return config{}, errors.New("cannot use -cache-size with -dupes collapse")
+	case *cacheSize > 0:
+		*dupes = dupesCache
+	}
+	if *summary != "" {
+		switch {
+		case summaryColumns[*summary] == nil:
+			return config{}, fmt.Errorf("unknown -summary %q", *summary)
+		case *dupes == dupesCollapse:
+			return config{}, errors.New("cannot use -summary with -dupes collapse")
+		case *format == formatMISP, *format == formatSTIX:
+			return config{}, fmt.Errorf("cannot use -summary with -format %s", *format)
+		case *annotate, *events, *csvIn:
+			return config{}, errors.New("cannot use -summary with -annotate, -events, or -csv-in")
+		case *fieldList != "", *templateText != "":
+			return config{}, errors.New("cannot use -fields or -template with -summary")
+		case *portColumn, *latency:
+			return config{}, errors.New("cannot use -port-column or -latency with -summary")
+		case *follow, *reopen, serving:
+			return config{}, errors.New("cannot use -follow, -reopen, -serve, or -grpc with -summary")
+		case *checkpoint != "":
+			return config{}, errors.New("cannot use -checkpoint with -summary")
+		}
+		if *dupes == dupesLookup {
+			*dupes = dupesCache // only the counts are needed from repeats
+		}
+	}
+	if *unique {
+		if *dedupe == dedupeDisk {
+			return config{}, errors.New("cannot use -unique with -dedupe disk")
+		}
+		*dedupe = dedupeMemory
+	}
+	switch *dedupe {
+	case "", dedupeMemory, dedupeDisk:
+	default:
+		return config{}, fmt.Errorf("unknown -dedupe store %q", *dedupe)
+	}
+	if *dedupe != "" {
+		switch {
+		case *dupes == dupesCollapse:
+			return config{}, errors.New("cannot use -unique or -dedupe with -dupes collapse, which already outputs each IP once")
+		case *annotate, *events, *csvIn:
+			return config{}, errors.New("cannot use -unique or -dedupe with -annotate, -events, or -csv-in")
+		case *summarizeRanges:
+			return config{}, errors.New("cannot use -unique or -dedupe with -summarize-ranges")
+		case *dedupe == dedupeDisk && (serving || *watchDir != ""):
+			return config{}, errors.New("cannot use -dedupe disk with -serve, -grpc, or -watch-dir")
+		}
+	}
+
+	switch *format {
+	case formatCSV:
+	case formatNDJSON:
+		if *annotate || *events {
+			return config{}, fmt.Errorf("cannot use -annotate or -events with -format %s", *format)
+		}
+	case formatJSON:
+		switch {
+		case *annotate, *events:
+			return config{}, fmt.Errorf("cannot use -annotate or -events with -format %s", *format)
+		case *follow, *reopen:
+			return config{}, fmt.Errorf("cannot use -follow or -reopen with -format %s", *format)
+		case *resume:
+			return config{}, fmt.Errorf("cannot use -resume with -format %s, which cannot be appended to", *format)
+		}
+	case formatMISP, formatSTIX:
+		switch {
+		case *dupes == dupesCollapse:
+			return config{}, fmt.Errorf("cannot use -dupes collapse with -format %s", *format)
+		case *annotate, *events:
+			return config{}, fmt.Errorf("cannot use -annotate or -events with -format %s", *format)
+		case *follow, *reopen:
+			return config{}, fmt.Errorf("cannot use -follow or -reopen with -format %s", *format)
+		case *resume:
+			return config{}, fmt.Errorf("cannot use -resume with -format %s, which cannot be appended to", *format)
+		}
+	case formatParquet:
+		switch {
+		case *outputFile == "" && *watchDir == "":
+			return config{}, errors.New("-format parquet requires -out")
+		case *annotate, *events:
+			return config{}, fmt.Errorf("cannot use -annotate or -events with -format %s", *format)
+		case *follow, *reopen:
+			return config{}, fmt.Errorf("cannot use -follow or -reopen with -format %s", *format)
+		case *resume:
+			return config{}, fmt.Errorf("cannot use -resume with -format %s, which cannot be appended to", *format)
+		case *encoding != encUTF8:
+			return config{}, fmt.Errorf("cannot use -encoding with -format %s", *format)
+		}
+	default:
+		return config{}, fmt.Errorf("unknown -format %q", *format)
+	}
+
+	switch {
+	case *header && *format != formatCSV:
+		return config{}, fmt.Errorf("cannot use -header with -format %s", *format)
+	case *fieldList != "" && (*format == formatMISP || *format == formatSTIX):
+		return config{}, fmt.Errorf("cannot use -fields with -format %s", *format)
+	case (*header || *fieldList != "") && (*annotate || *events):
+		return config{}, errors.New("cannot use -fields or -header with -annotate or -events")
+	case *header && *resume:
+		return config{}, errors.New("cannot use -header with -resume")
+	}
+
+	if *templateText != "" {
+		switch {
+		case *format != formatCSV:
+			return config{}, fmt.Errorf("cannot use -template with -format %s", *format)
+		case *header, *fieldList != "":
+			return config{}, errors.New("cannot use -header or -fields with -template")
+		case *annotate, *events, *csvIn:
+			return config{}, errors.New("cannot use -template with -annotate, -events, or -csv-in")
+		case serving:
+			return config{}, errors.New("cannot use -template with -serve or -grpc")
+		}
+	}
+
+	switch *encoding {
+	case encUTF8, encUTF8BOM, encUTF16LE:
+	default:
+		return config{}, fmt.Errorf("unknown -encoding %q", *encoding)
+	}
+
+	var decimal string
+	if *locale != "" {
+		switch {
+		case *format != formatCSV:
+			return config{}, fmt.Errorf("cannot use -locale with -format %s", *format)
+		case *templateText != "", *annotate, *events:
+			return config{}, errors.New("cannot use -locale with -template, -annotate, or -events")
+		case serving:
+			return config{}, errors.New("cannot use -locale with -serve or -grpc")
+		}
+		sep, err := decimalSeparator(*locale)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid -locale: %w", err)
+		}
+		decimal = sep
+	}
+
+	if *skip < 0 || *maxRecords < 0 {
+		return config{}, errors.New("-skip and -max cannot be negative")
+	}
+
+	if *checkpointEvery < 1 {
+		return config{}, errors.New("-checkpoint-every must be at least 1")
+	}
+	if *progress {
+		switch {
+		case *progressInterval <= 0:
+			return config{}, errors.New("-progress-interval must be positive")
+		case serving:
+			return config{}, errors.New("cannot use -progress with -serve or -grpc, which serve /metrics instead")
+		}
+	}
+	if *resume && *checkpoint == "" {
+		return config{}, errors.New("-resume requires -checkpoint")
+	}
+	if *checkpoint != "" && *dupes == dupesCollapse {
+		return config{}, errors.New("cannot use -checkpoint with -dupes collapse")
+	}
+
+	if *follow {
+		switch {
+		case *inputFile == "":
+			return config{}, errors.New("-follow requires -in")
+		case *checkpoint != "", *resume:
+			return config{}, errors.New("cannot use -checkpoint or -resume with -follow")
+		case *dupes == dupesCollapse:
+			return config{}, errors.New("cannot use -dupes collapse with -follow")
+		case *watchDir != "":
+			return config{}, errors.New("cannot use -watch-dir with -follow")
+		}
+	}
+
+	if *reopen {
+		switch {
+		case *inputFile == "":
+			return config{}, errors.New("-reopen requires -in")
+		case *checkpoint != "", *resume:
+			return config{}, errors.New("cannot use -checkpoint or -resume with -reopen")
+		}
+	}
+
+	if *watchDir != "" {
+		switch {
+		case *inputFile != "", *outputFile != "", *checkpoint != "", *resume:
+			return config{}, errors.New("cannot use -in, -out, -checkpoint, or -resume with -watch-dir")
+		case *stats, *statsOut != "":
+			return config{}, errors.New("cannot use -stats or -stats-out with -watch-dir")
+		case fs.NArg() > 0:
+			return config{}, errors.New("cannot use IP address arguments with -watch-dir")
+		}
+	}
+
+	if serving {
+		switch {
+		case *inputFile != "", *outputFile != "", *checkpoint != "", *resume:
+			return config{}, errors.New("cannot use -in, -out, -checkpoint, or -resume with -serve or -grpc")
+		case *watchDir != "", *follow, *reopen:
+			return config{}, errors.New("cannot use -watch-dir, -follow, or -reopen with -serve or -grpc")
+		case *annotate, *events, *csvIn:
+			return config{}, errors.New("cannot use -annotate, -events, or -csv-in with -serve or -grpc")
+		case *stats, *statsOut != "":
+			return config{}, errors.New("cannot use -stats or -stats-out with -serve or -grpc")
+		case *format != formatCSV, *header:
+			return config{}, errors.New("cannot use -format or -header with -serve or -grpc")
+		case *dupes == dupesCollapse:
+			return config{}, errors.New("cannot use -dupes collapse with -serve or -grpc")
+		case fs.NArg() > 0:
+			return config{}, errors.New("cannot use IP address arguments with -serve or -grpc")
+		}
+	}
+
+	if *deterministic {
+		switch {
+		case *latency:
+			return config{}, errors.New("cannot use -latency with -deterministic")
+		case *resume:
+			return config{}, errors.New("cannot use -resume with -deterministic")
+		case *execEnrich != "":
+			return config{}, errors.New("cannot use -exec-enrich with -deterministic")
+		case *rdns:
+			return config{}, errors.New("cannot use -rdns with -deterministic")
+		}
+	}
+
+	if *events {
+		switch {
+		case *annotate, *extract:
+			return config{}, errors.New("cannot use -annotate or -extract with -events")
+		case *dupes == dupesCollapse:
+			return config{}, errors.New("cannot use -dupes collapse with -events")
+		case *portColumn, *latency:
+			return config{}, errors.New("cannot use -port-column or -latency with -events")
+		case *eventIP == "", *eventTarget == "":
+			return config{}, errors.New("-event-ip and -event-target must not be empty")
+		}
+	}
+
+	if *csvIn {
+		switch {
+		case *annotate, *events, *extract:
+			return config{}, errors.New("cannot use -annotate, -events, or -extract with -csv-in")
+		case *format != formatCSV:
+			return config{}, fmt.Errorf("cannot use -format %s with -csv-in", *format)
+		case *dupes == dupesCollapse:
+			return config{}, errors.New("cannot use -dupes collapse with -csv-in")
+		case *portColumn, *latency:
+			return config{}, errors.New("cannot use -port-column or -latency with -csv-in")
+		case *ipColumn < 1:
+			return config{}, errors.New("-ip-column must be at least 1")
+		}
+	}
+
+	if *annotate {
+		switch {
+		case *dupes == dupesCollapse:
+			return config{}, errors.New("cannot use -dupes collapse with -annotate")
+		case *portColumn, *latency:
+			return config{}, errors.New("cannot use -port-column or -latency with -annotate")
+		}
+	}
+
+	if *execConcurrency < 1 {
+		return config{}, errors.New("-exec-concurrency must be at least 1")
+	}
+	if *workers < 1 {
+		return config{}, errors.New("-workers must be at least 1")
+	}
+	if *maxExpand < 1 {
+		return config{}, errors.New("-max-expand must be at least 1")
+	}
+	dbNames := strings.Split(*dbName, ",")
+	for n, name := range dbNames {
+		dbNames[n] = strings.TrimSpace(name)
+		if dbNames[n] == "" {
+			return config{}, errors.New("-db must not have an empty database")
+		}
+	}
+	if !slices.Contains(dbTypes, *dbType) {
+		return config{}, fmt.Errorf("unknown -type %q", *dbType)
+	}
+
+	fields := filterFields
+	var chain []enricher
+	if *asnDB != "" {
+		x := newASNEnricher(*asnDB)
+		chain = append(chain, x)
+		fields = append(slices.Clone(fields), x.addedColumns()...)
+	}
+
+	if *anonDB != "" {
+		x := newAnonEnricher(*anonDB)
+		chain = append(chain, x)
+		fields = append(slices.Clone(fields), x.addedColumns()...)
+	}
+
+	switch {
+	case *customDB != "" && *customFields == "":
+		return config{}, errors.New("-custom-db requires -custom-fields")
+	case *customDB == "" && *customFields != "":
+		return config{}, errors.New("-custom-fields requires -custom-db")
+	case *customDB != "":
+		x, err := newCustomEnricher(*customDB, *customFields, fields)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid -custom-fields: %w", err)
+		}
+		chain = append(chain, x)
+		fields = append(slices.Clone(fields), x.addedColumns()...)
+	}
+
+	if *pfx2as != "" {
+		x := newPrefixEnricher(*pfx2as)
+		chain = append(chain, x)
+		fields = append(slices.Clone(fields), x.addedColumns()...)
+	}
+
+	if len(hosting) > 0 {
+		x := newHostingEnricher(hosting)
+		chain = append(chain, x)
+		fields = append(slices.Clone(fields), x.addedColumns()...)
+	}
+
+	var distance *distanceEnricher
+	switch {
+	case *from != "" && *fromIP != "":
+		return config{}, errors.New("cannot use -from with -from-ip")
+	case *from != "":
+		lat, lon, err := parsePoint(*from)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid -from: %w", err)
+		}
+		distance = &distanceEnricher{lat: lat, lon: lon, miles: *units == unitsMi}
+	case *fromIP != "":
+		addr, err := netip.ParseAddr(*fromIP)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid -from-ip: %w", err)
+		}
+		distance = &distanceEnricher{fromIP: addr.Unmap(), miles: *units == unitsMi}
+	}
+	switch {
+	case *units != unitsKm && *units != unitsMi:
+		return config{}, fmt.Errorf("unknown -units %q", *units)
+	case *units != unitsKm && distance == nil:
+		return config{}, errors.New("-units requires -from or -from-ip")
+	}
+	if distance != nil {
+		chain = append(chain, distance)
+		fields = append(slices.Clone(fields), distance.addedColumns()...)
+	}
+
+	enrichLimit := *execConcurrency
+	if *rdns {
+		switch {
+		case *rdnsTimeout <= 0:
+			return config{}, errors.New("-rdns-timeout must be positive")
+		case *rdnsConcurrency < 1:
+			return config{}, errors.New("-rdns-concurrency must be at least 1")
+		}
+		x := newRDNSEnricher(*rdnsTimeout, *rdnsConcurrency)
+		chain = append(chain, x)
+		fields = append(slices.Clone(fields), x.addedColumns()...)
+		enrichLimit = max(enrichLimit, *rdnsConcurrency)
+	}
+
+	registered, fields, err := lookupEnrichers(enrich, fields)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid -enrich: %w", err)
+	}
+	chain = append(chain, registered...)
+	if *execEnrich != "" {
+		x, err := newExecEnricher(*execEnrich, *execColumns, fields)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid -exec-enrich: %w", err)
+		}
+		chain = append(chain, x)
+		fields = append(fields, x.columns...)
+	} else if *execColumns != "" {
+		return config{}, errors.New("-exec-columns requires -exec-enrich")
+	}
+
+	var recordScript *script
+	if *scriptName != "" {
+		recordScript, err = loadScript(*scriptName, fields)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid -script: %w", err)
+		}
+		fields = append(slices.Clone(fields), recordScript.columns...)
+	}
+
+	computed, fields, err := parseComputed(compute, fields)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid -compute: %w", err)
+	}
+
+	var recordAlerter *alerter
+	switch {
+	case (*alertFilter == "") != (*alertWebhook == ""):
+		return config{}, errors.New("-alert-filter and -alert-webhook must be used together")
+	case *alertFormat != alertGeneric && *alertFormat != alertSlack:
+		return config{}, fmt.Errorf("unknown -alert-format %q", *alertFormat)
+	case *alertFilter != "":
+		x, err := parseExpr(*alertFilter, fields)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid -alert-filter: %w", err)
+		}
+		recordAlerter = newAlerter(x, *alertWebhook, *alertFormat)
+	}
+
+	var labels []string
+	if *metricsAddr != "" {
+		for _, label := range strings.Split(*metricsLabels, ",") {
+			label = strings.TrimSpace(label)
+			if !slices.Contains(fields, label) {
+				return config{}, fmt.Errorf("invalid -metrics-labels: unknown field %q", label)
+			}
+			labels = append(labels, label)
+		}
+	}
+
+	outputs := fields
+	if *latency {
+		outputs = append(slices.Clone(outputs), "latency_us")
+	}
+	if *dupes == dupesCollapse {
+		outputs = append(slices.Clone(outputs), "count")
+	}
+
+	var outputFields []string
+	if *fieldList != "" {
+		if *portColumn {
+			return config{}, errors.New("cannot use -port-column with -fields, list port in -fields instead")
+		}
+		for _, name := range strings.Split(*fieldList, ",") {
+			name = strings.TrimSpace(name)
+			if !slices.Contains(outputs, name) {
+				return config{}, fmt.Errorf("invalid -fields: unknown field %q", name)
+			}
+			outputFields = append(outputFields, name)
+		}
+	}
+
+	var recordTemplate *template.Template
+	if *templateText != "" {
+		recordTemplate, err = parseTemplate(*templateText, outputs)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid -template: %w", err)
+		}
+	}
+
+	var filterExpr expr
+	if *filter != "" {
+		filterExpr, err = parseExpr(*filter, fields)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid -filter: %w", err)
+		}
+	}
+
+	listExpr := parseListFilters([]listFilter{
+		{"country_iso", *includeCountry, false},
+		{"country_iso", *excludeCountry, true},
+		{"continent_code", *includeContinent, false},
+		{"continent_code", *excludeContinent, true},
+		{"asn", *includeASN, false},
+		{"asn", *excludeASN, true},
+	}, *invert)
+	switch {
+	case listExpr == nil && *invert:
+		return config{}, errors.New("-invert requires an -include or -exclude flag")
+	case listExpr != nil && filterExpr != nil:
+		filterExpr = &logicalExpr{x: listExpr, y: filterExpr}
+	case listExpr != nil:
+		filterExpr = listExpr
+	}
+
+	return config{
+		dbName:        dbNames[0],
+		fallbackNames: dbNames[1:],
+		inputName:     *inputFile,
+		outputName:    *outputFile,
+		lang:          *lang,
+		listLangs:     *listLangs,
+		dbType:        *dbType,
+		delimiter:     delimRune,
+		private:       *private,
+		privateLabel:  *privLabel,
+		privateDB:     *privateDB,
+		skipInvalid:   *skipInvalid,
+		strict:        *strict,
+		keepMapped:    *keepMapped,
+		portColumn:    *portColumn,
+		clean:         *clean,
+		dupes:         *dupes,
+		cacheSize:     *cacheSize,
+		summary:       *summary,
+		dedupe:        *dedupe,
+		encoding:      *encoding,
+		format:        *format,
+		decimal:       decimal,
+		fields:        outputFields,
+		header:        *header,
+
+		sources: sources,
+
+		template:       recordTemplate,
+		templateFields: outputs,
+		skip:           *skip,
+		max:            *maxRecords,
+		maxAge:         *maxAge,
+		validate:       *validate,
+		verbose:        verbose(*verboseFlag),
+		latency:        *latency,
+		stats:          *stats,
+		statsOut:       *statsOut,
+
+		checkpoint:      *checkpoint,
+		checkpointEvery: *checkpointEvery,
+		resume:          *resume,
+
+		progress:         *progress,
+		progressInterval: *progressInterval,
+
+		deterministic: *deterministic,
+
+		annotate:       *annotate,
+		annotateFormat: *annotateFormat,
+		extract:        *extract,
+
+		events:      *events,
+		eventIP:     *eventIP,
+		eventTarget: *eventTarget,
+
+		csvIn:    *csvIn,
+		ipColumn: *ipColumn,
+
+		filter:   filterExpr,
+		computed: computed,
+
+		enrichers:   chain,
+		distance:    distance,
+		enrichLimit: enrichLimit,
+		workers:     *workers,
+
+		maxExpand:       *maxExpand,
+		summarizeRanges: *summarizeRanges,
+		script:          recordScript,
+
+		watchDir: *watchDir,
+		serve:    *serveAddr,
+		grpc:     *grpcAddr,
+
+		reloadInterval: *reloadInterval,
+		follow:         *follow,
+		reopen:         *reopen,
+
+		metricsAddr:   *metricsAddr,
+		metricsLabels: labels,
+
+		alerter: recordAlerter,
+	}, nil
+}
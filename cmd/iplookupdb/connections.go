@@ -0,0 +1,231 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// procTCPFiles are the Linux kernel tables of TCP sockets.
+var procTCPFiles = []string{"/proc/net/tcp", "/proc/net/tcp6"}
+
+// procEstablished is the state of established connections in procTCPFiles.
+const procEstablished = "01"
+
+// readProcTCP returns the remote addresses of the established connections
+// in the Linux kernel table r, such as /proc/net/tcp.
+func readProcTCP(r io.Reader) ([]netip.Addr, error) {
+	var peers []netip.Addr
+
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // skip header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != procEstablished {
+			continue
+		}
+
+		hexAddr, _, _ := strings.Cut(fields[2], ":")
+		b, err := hex.DecodeString(hexAddr)
+		if err != nil || (len(b) != 4 && len(b) != 16) {
+			return nil, fmt.Errorf("invalid address %q", fields[2])
+		}
+
+		// the address is written as 32-bit words in host byte order,
+		// which is little-endian on supported platforms
+		for n := 0; n < len(b); n += 4 {
+			binary.BigEndian.PutUint32(b[n:], binary.LittleEndian.Uint32(b[n:]))
+		}
+		addr, _ := netip.AddrFromSlice(b)
+		peers = append(peers, addr.Unmap())
+	}
+
+	return peers, scanner.Err()
+}
+
+// readSS returns the remote addresses of the established connections in
+// the output of ss -tn.
+func readSS(r io.Reader) ([]netip.Addr, error) {
+	var peers []netip.Addr
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "ESTAB" {
+			continue
+		}
+
+		addrPort, err := netip.ParseAddrPort(fields[4])
+		if err != nil {
+			// some versions do not bracket IPv6 addresses
+			host := fields[4][:max(strings.LastIndexByte(fields[4], ':'), 0)]
+			addr, err := netip.ParseAddr(strings.Trim(host, "[]"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid peer address %q", fields[4])
+			}
+			addrPort = netip.AddrPortFrom(addr, 0)
+		}
+		peers = append(peers, addrPort.Addr().WithZone("").Unmap())
+	}
+
+	return peers, scanner.Err()
+}
+
+// localPeers returns the remote addresses of the established TCP
+// connections of the local system, from the kernel tables if available and
+// otherwise from ss -tn.
+func localPeers() ([]netip.Addr, error) {
+	var peers []netip.Addr
+	for _, name := range procTCPFiles {
+		f, err := os.Open(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		p, err := readProcTCP(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		peers = append(peers, p...)
+	}
+	if peers != nil {
+		return peers, nil
+	}
+
+	out, err := exec.Command("ss", "-tn").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ss -tn: %w", err)
+	}
+	return readSS(bytes.NewReader(out))
+}
+
+// runConnections runs the connections subcommand, which shows where the
+// remote peers of the established TCP connections of the local system are
+// located, with the number of connections to each, refreshing periodically
+// with -refresh. Use -ss to read saved ss -tn output instead, or - for
+// stdin.
+func runConnections(args []string) int {
+	fs := flag.NewFlagSet("connections", flag.ContinueOnError)
+	dbName := fs.String("db", "GeoLite2-City.mmdb", "Path to the GeoLite2 City database")
+	lang := fs.String("lang", "en", "Language for GeoIP lookup results.")
+	refresh := fs.Duration("refresh", 0, "Interval to refresh the connections, e.g., 5s. 0 shows them once.")
+	ssName := fs.String("ss", "", "File with the output of ss -tn to read instead, or - for stdin.")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *refresh < 0 || (*refresh > 0 && *ssName != "") {
+		fmt.Fprintln(os.Stderr, "Invalid option: -refresh must be positive and cannot be used with -ss")
+		return exitUsage
+	}
+
+	db, err := geoip2.Open(*dbName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return exitDB
+	}
+	defer db.Close()
+
+	cfg := config{lang: *lang, private: privateLabel, dupes: dupesCache}
+	p := newProcessor(io.Discard, cfg, db, nil)
+
+	peers := localPeers
+	switch *ssName {
+	case "":
+	case "-":
+		peers = func() ([]netip.Addr, error) { return readSS(os.Stdin) }
+	default:
+		peers = func() ([]netip.Addr, error) {
+			f, err := os.Open(*ssName)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			return readSS(f)
+		}
+	}
+
+	for {
+		addrs, err := peers()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read connections: %v\n", err)
+			return exitInput
+		}
+
+		if *refresh > 0 {
+			// clear the screen and show the time of the refresh
+			fmt.Printf("\x1b[H\x1b[2J%s\n\n", time.Now().Format(time.DateTime))
+		}
+		if err := writeConnections(os.Stdout, p, addrs); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write output: %v\n", err)
+			return exitOutput
+		}
+
+		if *refresh == 0 {
+			return exitOK
+		}
+		time.Sleep(*refresh)
+	}
+}
+
+// writeConnections writes a CSV row with the location of each remote peer
+// in addrs and the number of connections to it, with the most connections
+// first. Loopback peers are omitted.
+func writeConnections(w io.Writer, p *processor, addrs []netip.Addr) error {
+	counts := make(map[netip.Addr]int)
+	var peers []netip.Addr
+	for _, addr := range addrs {
+		if addr.IsLoopback() || addr.IsUnspecified() {
+			continue
+		}
+		if counts[addr] == 0 {
+			peers = append(peers, addr)
+		}
+		counts[addr]++
+	}
+	slices.SortFunc(peers, func(a, b netip.Addr) int {
+		if c := cmp.Compare(counts[b], counts[a]); c != 0 {
+			return c
+		}
+		return a.Compare(b)
+	})
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"ip", "city", "subdivision", "country", "connections"})
+	for _, addr := range peers {
+		loc, _, err := p.locate(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", addr, err)
+		}
+		fields := []string{addr.String(), loc.city, loc.subdivision, loc.country}
+		for n := range fields {
+			if fields[n] == "" {
+				fields[n] = "unknown"
+			}
+		}
+		cw.Write(append(fields, strconv.Itoa(counts[addr])))
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,11 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+//go:build !windows
+
+package main
+
+// setupConsole does nothing since terminals on other platforms handle UTF-8.
+func setupConsole() (restore func()) {
+	return func() {}
+}
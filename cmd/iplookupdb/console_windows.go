@@ -0,0 +1,33 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import "golang.org/x/sys/windows"
+
+var (
+	kernel32               = windows.NewLazySystemDLL("kernel32.dll")
+	procGetConsoleOutputCP = kernel32.NewProc("GetConsoleOutputCP")
+	procSetConsoleOutputCP = kernel32.NewProc("SetConsoleOutputCP")
+)
+
+// setupConsole sets the console output code page to UTF-8 so non-ASCII
+// names, such as localized city names, render correctly on Windows terminals
+// and in programs that read the output through the console. The returned func
+// restores the original code page.
+func setupConsole() (restore func()) {
+	const cpUTF8 = 65001
+
+	if procGetConsoleOutputCP.Find() != nil || procSetConsoleOutputCP.Find() != nil {
+		return func() {}
+	}
+
+	orig, _, _ := procGetConsoleOutputCP.Call()
+	if orig == 0 || orig == cpUTF8 {
+		// no console or already UTF-8
+		return func() {}
+	}
+
+	procSetConsoleOutputCP.Call(cpUTF8)
+	return func() { procSetConsoleOutputCP.Call(orig) }
+}
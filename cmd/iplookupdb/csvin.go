@@ -0,0 +1,106 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+)
+
+// csvRowIP returns the field in the 1-based column n of the CSV row line,
+// which uses the delimiter.
+func csvRowIP(line string, delimiter rune, n int) (string, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	fields, err := r.Read()
+	if err != nil {
+		return "", err
+	}
+	if n > len(fields) {
+		return "", fmt.Errorf("row has %d columns", len(fields))
+	}
+	return fields[n-1], nil
+}
+
+// appendCSV returns line with fields appended as CSV fields using the
+// delimiter. The fields of line are not changed, so their quoting is kept.
+func appendCSV(line string, delimiter rune, fields []string) string {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Comma = delimiter
+	w.Write(fields)
+	w.Flush()
+	return line + string(delimiter) + strings.TrimRight(sb.String(), "\n")
+}
+
+// processCSVRow writes the CSV row in line, such as from a SIEM export,
+// with the output columns for the IP in the -ip-column appended. Rows
+// without a valid IP are written with empty columns. With -header, the
+// first row is a header and the names of the columns are appended to it.
+func (p *processor) processCSVRow(line string) {
+	if p.cfg.header && !p.csvHeaderDone {
+		p.csvHeaderDone = true
+		p.writeLine(appendCSV(line, p.cfg.delimiter, p.columns))
+		return
+	}
+
+	ipStr, err := csvRowIP(line, p.cfg.delimiter, p.cfg.ipColumn)
+	if err != nil {
+		p.invalid++
+		fmt.Fprintf(os.Stderr, "Invalid row %q: %v\n", strings.TrimSpace(line), err)
+		p.outputCSVRow(line, nil)
+		return
+	}
+
+	var e env
+	addr, port, err := lookup.ParseIP(ipStr)
+	if err != nil {
+		p.invalid++
+		if !p.cfg.skipInvalid {
+			fmt.Fprintf(os.Stderr, "Cannot convert %q to IP\n", ipStr)
+		}
+	} else {
+		if p.unique != nil {
+			p.unique[addr.Unmap()] = struct{}{}
+		}
+		loc, ok, err := p.locate(addr.Unmap())
+		if err != nil {
+			p.lookupErrors++
+			fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", addr.Unmap(), err)
+		} else if ok {
+			e = recordEnv(outputAddr(addr, p.cfg.keepMapped), port, loc)
+		}
+	}
+
+	p.enrich(e, func() { p.outputCSVRow(line, e) })
+}
+
+// outputCSVRow writes the row in line with the columns of the record e
+// appended, or empty columns if there is no record, when the record matches
+// the filter.
+func (p *processor) outputCSVRow(line string, e env) {
+	if e == nil {
+		if p.match(e) {
+			p.writeLine(appendCSV(line, p.cfg.delimiter, make([]string, len(p.columns))))
+		}
+		return
+	}
+
+	if !p.runScript(e) {
+		return
+	}
+	if _, ok := p.evalRecord(e); !ok {
+		return
+	}
+
+	p.observe(e)
+	p.writeLine(appendCSV(line, p.cfg.delimiter, csvFields(record{p.columns, e}, p.cfg.decimal)))
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// customEnricher adds fields from a custom MMDB, such as an internal db of
+// office and VPN networks, whose records are not in a GeoIP2 layout. The
+// records are decoded without a schema, so any key can be added.
+type customEnricher struct {
+	name   string // of the database, opened by open
+	db     *maxminddb.Reader
+	fields []customField
+}
+
+// customField is a key of the records of a custom db added as a column.
+type customField struct {
+	column string
+	path   []string // keys of the nested maps to the value
+}
+
+// newCustomEnricher returns an enricher using the custom database name to add
+// the fields, given as a comma-separated list of keys, such as
+// site,vlan,owner. A key of a nested map is given as a dotted path, such as
+// location.building, and is added as the column location_building. The
+// columns must not be one of the existing record fields.
+func newCustomEnricher(name, fields string, existing []string) (*customEnricher, error) {
+	x := &customEnricher{name: name}
+	var columns []string
+	for _, key := range strings.Split(fields, ",") {
+		key = strings.TrimSpace(key)
+		col := strings.ReplaceAll(key, ".", "_")
+		if !isIdent(col) || slices.Contains(strings.Split(key, "."), "") {
+			return nil, fmt.Errorf("%q is not a valid field", key)
+		}
+		if slices.Contains(existing, col) || slices.Contains(columns, col) {
+			return nil, fmt.Errorf("column %q is already a field", col)
+		}
+		columns = append(columns, col)
+		x.fields = append(x.fields, customField{col, strings.Split(key, ".")})
+	}
+
+	return x, nil
+}
+
+// open opens the custom database.
+func (x *customEnricher) open() error {
+	db, err := maxminddb.Open(x.name)
+	if err != nil {
+		return fmt.Errorf("-custom-db: %w", err)
+	}
+	x.db = db
+	return nil
+}
+
+func (x *customEnricher) addedColumns() []string {
+	columns := make([]string, len(x.fields))
+	for n, f := range x.fields {
+		columns[n] = f.column
+	}
+	return columns
+}
+
+// enrich adds the fields of the custom db to the record e, which are empty if
+// the IP or the key is not in the db.
+func (x *customEnricher) enrich(e env) error {
+	for _, f := range x.fields {
+		e[f.column] = ""
+	}
+
+	addr, err := netip.ParseAddr(formatValue(e["ip"]))
+	if err != nil {
+		return nil // changed by an earlier enricher
+	}
+	var record map[string]any
+	if err := x.db.Lookup(net.IP(addr.Unmap().AsSlice()), &record); err != nil {
+		return err
+	}
+
+	for _, f := range x.fields {
+		var v any = record
+		for _, key := range f.path {
+			m, ok := v.(map[string]any)
+			if !ok {
+				v = nil
+				break
+			}
+			v = m[key]
+		}
+		if v != nil {
+			e[f.column] = customValue(v)
+		}
+	}
+	return nil
+}
+
+// customValue returns the value v decoded from a custom db as a record value.
+// Numbers that fit are float64, and maps and slices are kept as JSON.
+func customValue(v any) any {
+	switch v := v.(type) {
+	case string, bool, float64:
+		return v
+	case float32:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		if v <= 1<<53 {
+			return float64(v)
+		}
+	case []byte:
+		return fmt.Sprintf("%x", v)
+	case map[string]any, []any:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(v) // such as a uint128 as a *big.Int
+}
+
+// Close closes the custom database.
+func (x *customEnricher) Close() error {
+	if x.db == nil {
+		return nil
+	}
+	return x.db.Close()
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// runDBInfo runs the dbinfo subcommand, which prints the metadata of each
+// database and, with -max-age, checks that none are stale, so monitoring can
+// alert when a database is no longer being updated. It takes the same flags
+// as a lookup, with defaults from the environment and config file, and
+// checks the -db databases and the -asn-db, -anon-db, and -custom-db
+// databases unless databases are given as arguments.
+func runDBInfo(args []string) int {
+	fs := flag.NewFlagSet("dbinfo", flag.ContinueOnError)
+	cfg, err := parseFlags(fs, args)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "Invalid option: %v\n", err)
+		}
+		return exitUsage
+	}
+
+	names := fs.Args()
+	if len(names) == 0 {
+		names = append([]string{cfg.dbName}, cfg.fallbackNames...)
+		for _, name := range []string{"asn-db", "anon-db", "custom-db"} {
+			if v := fs.Lookup(name).Value.String(); v != "" {
+				names = append(names, v)
+			}
+		}
+	}
+
+	status := exitOK
+	for n, name := range names {
+		name = strings.TrimSpace(name)
+		if n > 0 {
+			fmt.Println()
+		}
+
+		db, err := geoip2.Open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+			status = exitDB
+			continue
+		}
+		dbInfo(os.Stdout, name, db, time.Now())
+		if err := checkAge(db, cfg.maxAge); err != nil {
+			fmt.Fprintf(os.Stderr, "Stale database: %s: %v\n", name, err)
+			status = exitDB
+		}
+		db.Close()
+	}
+	return status
+}
+
+// dbInfo writes the metadata of the database name to w, with its age at now.
+func dbInfo(w io.Writer, name string, db *geoip2.Reader, now time.Time) {
+	m := db.Metadata()
+	built := time.Unix(int64(m.BuildEpoch), 0).UTC()
+
+	fmt.Fprintf(w, "database:    %s\n", name)
+	fmt.Fprintf(w, "type:        %s\n", m.DatabaseType)
+	fmt.Fprintf(w, "build epoch: %d\n", m.BuildEpoch)
+	fmt.Fprintf(w, "built:       %s (%s ago)\n", built.Format(time.RFC3339), now.Sub(built).Round(time.Second))
+	fmt.Fprintf(w, "nodes:       %d\n", m.NodeCount)
+	fmt.Fprintf(w, "ip version:  %d\n", m.IPVersion)
+	fmt.Fprintf(w, "record size: %d bits\n", m.RecordSize)
+	fmt.Fprintf(w, "languages:   %s\n", strings.Join(m.Languages, ","))
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+)
+
+// Database types of -type, which determine the lookup and the default
+// output columns.
+const (
+	dbTypeAuto        = "auto" // detect from the database metadata
+	dbTypeCity        = "city"
+	dbTypeCountry     = "country"
+	dbTypeASN         = "asn"
+	dbTypeAnonymousIP = "anonymous-ip"
+	dbTypeISP         = "isp"
+	dbTypeDomain      = "domain"
+)
+
+// dbTypes are the valid values of -type.
+var dbTypes = []string{
+	dbTypeAuto, dbTypeCity, dbTypeCountry, dbTypeASN, dbTypeAnonymousIP, dbTypeISP, dbTypeDomain,
+}
+
+// typeColumns are the default output columns, after ip, of each database
+// type.
+var typeColumns = map[string][]string{
+	dbTypeCity:    {"city", "subdivision", "country"},
+	dbTypeCountry: {"country"},
+	dbTypeASN:     {"asn", "as_org"},
+	dbTypeAnonymousIP: {
+		"is_anonymous", "is_anonymous_vpn", "is_hosting_provider",
+		"is_public_proxy", "is_residential_proxy", "is_tor_exit_node",
+	},
+	dbTypeISP:    {"isp", "organization", "asn", "as_org"},
+	dbTypeDomain: {"domain"},
+}
+
+// detectDBType returns the -type of a database from its metadata type, such
+// as GeoLite2-Country, or an error if it is not supported.
+func detectDBType(databaseType string) (string, error) {
+	switch {
+	case strings.Contains(databaseType, "City") || strings.Contains(databaseType, "Enterprise"):
+		return dbTypeCity, nil
+	case strings.Contains(databaseType, "Country"):
+		return dbTypeCountry, nil
+	case strings.Contains(databaseType, "ASN"):
+		return dbTypeASN, nil
+	case strings.Contains(databaseType, "Anonymous-IP"):
+		return dbTypeAnonymousIP, nil
+	case strings.Contains(databaseType, "ISP"):
+		return dbTypeISP, nil
+	case strings.Contains(databaseType, "Domain"):
+		return dbTypeDomain, nil
+	}
+	return "", fmt.Errorf("unsupported database type %q, use -type", databaseType)
+}
+
+// resolveDBType returns the type of db, which is dbType unless it is auto.
+func resolveDBType(db geoDB, dbType string) (string, error) {
+	if dbType != dbTypeAuto {
+		return dbType, nil
+	}
+	return detectDBType(db.Metadata().DatabaseType)
+}
+
+// dbType returns the type of the db, which is city unless another type
+// was given or detected.
+func (p *processor) dbType() string {
+	if p.cfg.dbType == "" || p.cfg.dbType == dbTypeAuto {
+		return dbTypeCity
+	}
+	return p.cfg.dbType
+}
+
+// anonymousFlags are the fields of an Anonymous-IP database.
+type anonymousFlags struct {
+	anonymous, anonymousVPN, hostingProvider   bool
+	publicProxy, residentialProxy, torExitNode bool
+}
+
+// typeLocation returns the location of ip in a database of a type other
+// than City, with names in the first of the languages in lang that has
+// one.
+func typeLocation(db geoDB, dbType string, ip net.IP, lang string) (location, error) {
+	loc := location{dbType: dbType}
+	switch dbType {
+	case dbTypeCountry:
+		r, err := db.Country(ip)
+		if err != nil {
+			return location{}, err
+		}
+		loc.country, loc.countryISO = lookup.Name(r.Country.Names, lang), r.Country.IsoCode
+		loc.continent, loc.continentCode = lookup.Name(r.Continent.Names, lang), r.Continent.Code
+		return loc, nil
+	case dbTypeASN:
+		r, err := db.ASN(ip)
+		if err != nil {
+			return location{}, err
+		}
+		loc.asn, loc.asOrg = asnString(r.AutonomousSystemNumber), r.AutonomousSystemOrganization
+		return loc, nil
+	case dbTypeAnonymousIP:
+		r, err := db.AnonymousIP(ip)
+		if err != nil {
+			return location{}, err
+		}
+		loc.anonymous = anonymousFlags{
+			anonymous:        r.IsAnonymous,
+			anonymousVPN:     r.IsAnonymousVPN,
+			hostingProvider:  r.IsHostingProvider,
+			publicProxy:      r.IsPublicProxy,
+			residentialProxy: r.IsResidentialProxy,
+			torExitNode:      r.IsTorExitNode,
+		}
+		return loc, nil
+	case dbTypeISP:
+		r, err := db.ISP(ip)
+		if err != nil {
+			return location{}, err
+		}
+		loc.asn, loc.asOrg = asnString(r.AutonomousSystemNumber), r.AutonomousSystemOrganization
+		loc.isp, loc.organization = r.ISP, r.Organization
+		return loc, nil
+	case dbTypeDomain:
+		r, err := db.Domain(ip)
+		if err != nil {
+			return location{}, err
+		}
+		loc.domain = r.Domain
+		return loc, nil
+	}
+	return location{}, fmt.Errorf("unknown database type %q", dbType)
+}
+
+// addTypeFields adds the fields of the database type of loc to e.
+func addTypeFields(e env, loc location) {
+	switch loc.dbType {
+	case dbTypeASN:
+		e["asn"], e["as_org"] = loc.asn, loc.asOrg
+	case dbTypeAnonymousIP:
+		e["is_anonymous"] = loc.anonymous.anonymous
+		e["is_anonymous_vpn"] = loc.anonymous.anonymousVPN
+		e["is_hosting_provider"] = loc.anonymous.hostingProvider
+		e["is_public_proxy"] = loc.anonymous.publicProxy
+		e["is_residential_proxy"] = loc.anonymous.residentialProxy
+		e["is_tor_exit_node"] = loc.anonymous.torExitNode
+	case dbTypeISP:
+		e["asn"], e["as_org"] = loc.asn, loc.asOrg
+		e["isp"], e["organization"] = loc.isp, loc.organization
+	case dbTypeDomain:
+		e["domain"] = loc.domain
+	}
+}
+
+// asnString returns the autonomous system number n as a string, or "" if it
+// is unknown.
+func asnString(n uint) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(n), 10)
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"hash/maphash"
+	"io"
+	"net/netip"
+	"os"
+)
+
+// Stores of -dedupe for the IPs already output.
+const (
+	dedupeMemory = "memory" // a map, the same as -unique
+	dedupeDisk   = "disk"   // a hash table in a temporary file
+)
+
+// ipSet is a set of IPs, such as those already output by -dedupe.
+type ipSet interface {
+	// add adds addr to the set and reports whether it was not already in
+	// the set.
+	add(addr netip.Addr) (added bool, err error)
+
+	// close releases the resources of the set.
+	close() error
+}
+
+// memorySet is an ipSet held in memory.
+type memorySet map[netip.Addr]struct{}
+
+func (s memorySet) add(addr netip.Addr) (bool, error) {
+	if _, found := s[addr]; found {
+		return false, nil
+	}
+	s[addr] = struct{}{}
+	return true, nil
+}
+
+func (s memorySet) close() error {
+	return nil
+}
+
+// Layout of the hash table of a diskSet.
+const (
+	diskSlotSize  = 17      // a used byte and the 16-byte IPv6 form of the IP
+	diskSlotsInit = 1 << 16 // slots of a new table, a power of 2
+)
+
+// diskSet is an ipSet held in a temporary file, so the IPs of inputs with
+// hundreds of millions of lines do not need to fit in memory. The file is an
+// open-addressing hash table of fixed-size slots, using linear probing, that
+// doubles in size when half full. The file is sparse until slots are used.
+type diskSet struct {
+	f     *os.File
+	dir   string // of the file, empty for the default
+	seed  maphash.Seed
+	slots uint64 // number of slots, a power of 2
+	n     uint64 // number of IPs in the set
+	buf   [diskSlotSize]byte
+}
+
+// newDiskSet returns an empty diskSet in a temporary file in dir, or the
+// default directory for temporary files, such as $TMPDIR, if dir is empty.
+func newDiskSet(dir string) (*diskSet, error) {
+	f, err := newDiskTable(dir, diskSlotsInit)
+	if err != nil {
+		return nil, err
+	}
+	return &diskSet{f: f, dir: dir, seed: maphash.MakeSeed(), slots: diskSlotsInit}, nil
+}
+
+// newDiskTable returns a temporary file with room for slots empty slots. The
+// file is removed once created where the OS allows, so it is not left
+// behind if the program exits without closing it.
+func newDiskTable(dir string, slots uint64) (*os.File, error) {
+	f, err := os.CreateTemp(dir, "iplookupdb-dedupe-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name()) // fails on Windows, so close removes it instead
+
+	if err := f.Truncate(int64(slots * diskSlotSize)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *diskSet) add(addr netip.Addr) (bool, error) {
+	ip := addr.As16()
+	added, err := s.insert(ip)
+	if err != nil || !added {
+		return false, err
+	}
+
+	s.n++
+	if s.n*2 > s.slots {
+		return true, s.grow()
+	}
+	return true, nil
+}
+
+// insert adds ip to the table and reports whether it was not already in it.
+func (s *diskSet) insert(ip [16]byte) (bool, error) {
+	mask := s.slots - 1
+	for i := maphash.Bytes(s.seed, ip[:]) & mask; ; i = (i + 1) & mask {
+		off := int64(i * diskSlotSize)
+		if _, err := s.f.ReadAt(s.buf[:], off); err != nil {
+			return false, err
+		}
+		if s.buf[0] == 0 {
+			s.buf[0] = 1
+			copy(s.buf[1:], ip[:])
+			_, err := s.f.WriteAt(s.buf[:], off)
+			return err == nil, err
+		}
+		if [16]byte(s.buf[1:]) == ip {
+			return false, nil
+		}
+	}
+}
+
+// grow doubles the slots of the table by rehashing its IPs into a new file.
+// The set is unchanged if it fails.
+func (s *diskSet) grow() error {
+	f, err := newDiskTable(s.dir, s.slots*2)
+	if err != nil {
+		return err
+	}
+	next := &diskSet{f: f, dir: s.dir, seed: s.seed, slots: s.slots * 2, n: s.n}
+	if err := next.rehash(s.f, s.slots); err != nil {
+		next.close()
+		return err
+	}
+
+	old := s.f
+	s.f, s.slots = next.f, next.slots
+	err = old.Close()
+	os.Remove(old.Name())
+	return err
+}
+
+// rehash inserts the IPs in the slots of the table in old.
+func (s *diskSet) rehash(old *os.File, slots uint64) error {
+	r := bufio.NewReaderSize(io.NewSectionReader(old, 0, int64(slots*diskSlotSize)), 1<<20)
+	var slot [diskSlotSize]byte
+	for {
+		_, err := io.ReadFull(r, slot[:])
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if slot[0] != 0 {
+			if _, err := s.insert([16]byte(slot[1:])); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *diskSet) close() error {
+	err := s.f.Close()
+	os.Remove(s.f.Name())
+	return err
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"testing"
+)
+
+// testIP returns a distinct IPv4 address for each n.
+func testIP(n uint32) netip.Addr {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], 0x0a000000+n)
+	return netip.AddrFrom4(b)
+}
+
+func TestDiskSetGrow(t *testing.T) {
+	s, err := newDiskSet(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	// enough IPs for the table to double several times
+	const count = 3 * diskSlotsInit
+	for n := uint32(0); n < count; n++ {
+		added, err := s.add(testIP(n))
+		if err != nil {
+			t.Fatalf("add(%v) error: %v", testIP(n), err)
+		}
+		if !added {
+			t.Fatalf("add(%v) = false, want true", testIP(n))
+		}
+	}
+	if s.slots < 8*diskSlotsInit {
+		t.Errorf("slots = %d, want at least %d", s.slots, 8*diskSlotsInit)
+	}
+
+	for n := uint32(0); n < count; n += 997 {
+		if added, err := s.add(testIP(n)); err != nil || added {
+			t.Errorf("add(%v) of a duplicate = %v, %v, want false", testIP(n), added, err)
+		}
+	}
+	if added, err := s.add(testIP(count)); err != nil || !added {
+		t.Errorf("add(%v) = %v, %v, want true", testIP(count), added, err)
+	}
+}
+
+func TestDiskSetGrowError(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newDiskSet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	// the table cannot grow once its directory is gone
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	n := uint32(0)
+	for ; n < diskSlotsInit/2+1; n++ {
+		if _, err := s.add(testIP(n)); err != nil {
+			break
+		}
+	}
+	if n > diskSlotsInit/2 {
+		t.Skip("temporary file created in a removed directory")
+	}
+	if s.slots != diskSlotsInit {
+		t.Fatalf("slots = %d after a failed grow, want %d", s.slots, diskSlotsInit)
+	}
+
+	// the set is still usable and has every IP added
+	for i := uint32(0); i <= n; i++ {
+		if added, _ := s.add(testIP(i)); added {
+			t.Errorf("add(%v) of a duplicate = true, want false", testIP(i))
+		}
+	}
+}
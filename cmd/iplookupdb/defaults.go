@@ -0,0 +1,219 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is the prefix of the environment variables that set flags, such
+// as IPLOOKUPDB_DB for -db.
+const envPrefix = "IPLOOKUPDB_"
+
+// envName returns the environment variable for the flag name, such as
+// IPLOOKUPDB_ASN_DB for asn-db.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// defaultConfigFile returns the config file read if -config is not given,
+// such as ~/.config/iplookupdb/config.toml, or "" if there is no user
+// config directory.
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "iplookupdb", "config.toml")
+}
+
+// applyDefaults sets the flags of fs that were not given on the command line
+// from their environment variable or, if not set, from the config file
+// name, if it exists. A missing config file is an error if explicit is
+// true. The source of each flag set, such as env IPLOOKUPDB_DB, is returned
+// by flag name.
+func applyDefaults(flags *flag.FlagSet, name string, explicit bool) (map[string]string, error) {
+	var settings map[string][]string
+	if name != "" {
+		var err error
+		settings, err = readConfigFile(name)
+		if errors.Is(err, fs.ErrNotExist) && !explicit {
+			name, err = "", nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for key := range settings {
+		if key == "config" || flags.Lookup(key) == nil {
+			return nil, fmt.Errorf("%s: unknown setting %q", name, key)
+		}
+	}
+
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	sources := make(map[string]string)
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] || f.Name == "config" {
+			return
+		}
+
+		if v, found := os.LookupEnv(envName(f.Name)); found {
+			if setErr := flags.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid %s: %w", envName(f.Name), setErr)
+			}
+			sources[f.Name] = "env " + envName(f.Name)
+			return
+		}
+
+		values, found := settings[f.Name]
+		if !found {
+			return
+		}
+		for _, v := range values {
+			if setErr := flags.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("%s: invalid %s: %w", name, f.Name, setErr)
+				return
+			}
+		}
+		sources[f.Name] = "config " + name
+	})
+	return sources, err
+}
+
+// readConfigFile reads the settings in the config file name, which uses a
+// subset of TOML with a key = value pair on each line, such as:
+//
+//	# defaults for iplookupdb
+//	db = "/var/lib/GeoIP/GeoLite2-City.mmdb"
+//	asn_db = "/var/lib/GeoIP/GeoLite2-ASN.mmdb"
+//	fields = "ip,country_iso,asn"
+//	workers = 4
+//	compute = ["risk=is_private ? 0 : 50"]
+//
+// Keys are flag names, with either dashes or underscores. Values are quoted
+// strings, bare numbers or booleans, or arrays of them for flags that may
+// be repeated. Tables are not supported.
+func readConfigFile(name string) (map[string][]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	settings := make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("%s:%d: tables are not supported", name, n)
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected key = value", name, n)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		if _, dup := settings[key]; dup {
+			return nil, fmt.Errorf("%s:%d: %s is set twice", name, n, key)
+		}
+
+		values, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		settings[key] = values
+	}
+	return settings, scanner.Err()
+}
+
+// parseConfigValue parses the value of a config file setting, which is a
+// scalar or an array of scalars, and an optional comment.
+func parseConfigValue(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "[") {
+		v, rest, err := parseConfigScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkConfigEnd(rest); err != nil {
+			return nil, err
+		}
+		return []string{v}, nil
+	}
+
+	var values []string
+	s = strings.TrimSpace(s[1:])
+	for !strings.HasPrefix(s, "]") {
+		v, rest, err := parseConfigScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		s = strings.TrimSpace(rest)
+		if strings.HasPrefix(s, ",") {
+			s = strings.TrimSpace(s[1:])
+		} else if !strings.HasPrefix(s, "]") {
+			return nil, errors.New("expected , or ] in array")
+		}
+	}
+	return values, checkConfigEnd(s[1:])
+}
+
+// parseConfigScalar parses the quoted string, or the bare value, at the start
+// of s and returns it and the rest of s.
+func parseConfigScalar(s string) (v, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		for end := 1; end < len(s); end++ {
+			switch s[end] {
+			case '\\':
+				end++
+			case '"':
+				v, err = strconv.Unquote(s[:end+1])
+				return v, s[end+1:], err
+			}
+		}
+		return "", "", errors.New("unterminated string")
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+
+	end := strings.IndexAny(s, ",]#")
+	if end < 0 {
+		end = len(s)
+	}
+	v = strings.TrimSpace(s[:end])
+	if v == "" {
+		return "", "", errors.New("missing value")
+	}
+	return v, s[end:], nil
+}
+
+// checkConfigEnd returns an error if rest, which follows a value, is not
+// empty or a comment.
+func checkConfigEnd(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected %q after value", rest)
+	}
+	return nil
+}
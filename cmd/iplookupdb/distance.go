@@ -0,0 +1,108 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// earthRadiusKm is the mean radius of the Earth in kilometers.
+const earthRadiusKm = 6371.0088
+
+// kmPerMile is the number of kilometers in a mile.
+const kmPerMile = 1.609344
+
+// Units of distances.
+const (
+	unitsKm = "km" // kilometers, added as distance_km
+	unitsMi = "mi" // miles, added as distance_mi
+)
+
+// distanceKm returns the great-circle distance in kilometers between two
+// points given in degrees, using the haversine formula.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := rad(lat2-lat1), rad(lon2-lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// parsePoint parses s as latitude,longitude in degrees, such as
+// 51.5,-0.13.
+func parsePoint(s string) (lat, lon float64, err error) {
+	latStr, lonStr, found := strings.Cut(s, ",")
+	if !found {
+		return 0, 0, errors.New("must be latitude,longitude")
+	}
+	lat, err = strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("invalid latitude %q", latStr)
+	}
+	lon, err = strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("invalid longitude %q", lonStr)
+	}
+	return lat, lon, nil
+}
+
+// distanceEnricher adds the distance_km, or distance_mi in miles, from a
+// reference point to the location of each record, such as to flag logins far
+// from a user's usual location. The distance is empty if the location has no
+// coordinates.
+type distanceEnricher struct {
+	lat, lon float64
+	fromIP   netip.Addr // looked up by resolve to set the point, if valid
+	miles    bool       // add distance_mi instead of distance_km
+}
+
+// column returns the name of the distance field.
+func (x *distanceEnricher) column() string {
+	if x.miles {
+		return "distance_mi"
+	}
+	return "distance_km"
+}
+
+func (x *distanceEnricher) addedColumns() []string {
+	return []string{x.column()}
+}
+
+func (x *distanceEnricher) enrich(e env) error {
+	e[x.column()] = nil
+
+	lat, okLat := e["latitude"].(float64)
+	lon, okLon := e["longitude"].(float64)
+	if okLat && okLon {
+		d := distanceKm(x.lat, x.lon, lat, lon)
+		if x.miles {
+			d /= kmPerMile
+		}
+		e[x.column()] = math.Round(d*10) / 10
+	}
+	return nil
+}
+
+// resolve sets the reference point to the location of fromIP in db, if it
+// is valid.
+func (x *distanceEnricher) resolve(db geoDB) error {
+	if !x.fromIP.IsValid() {
+		return nil
+	}
+	city, err := db.City(net.IP(x.fromIP.AsSlice()))
+	if err != nil {
+		return err
+	}
+	if city.Location.Latitude == 0 && city.Location.Longitude == 0 && city.Location.AccuracyRadius == 0 {
+		return fmt.Errorf("no coordinates for %v", x.fromIP)
+	}
+	x.lat, x.lon = city.Location.Latitude, city.Location.Longitude
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Output encodings.
+const (
+	encUTF8    = "utf-8"     // UTF-8 without a byte order mark
+	encUTF8BOM = "utf-8-bom" // UTF-8 with a byte order mark
+	encUTF16LE = "utf-16le"  // UTF-16 little-endian with a byte order mark
+)
+
+// encodeOutput returns a writer that writes to w in the named encoding.
+// If bom is true, a byte order mark is written first if the encoding uses one.
+// It is false when appending to existing output.
+func encodeOutput(w io.Writer, encoding string, bom bool) (io.Writer, error) {
+	var err error
+	switch encoding {
+	case encUTF8:
+		return w, nil
+	case encUTF8BOM:
+		if bom {
+			_, err = w.Write([]byte{0xEF, 0xBB, 0xBF})
+		}
+		return w, err
+	case encUTF16LE:
+		if bom {
+			_, err = w.Write([]byte{0xFF, 0xFE})
+		}
+		return &utf16Writer{w: w}, err
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// utf16Writer converts UTF-8 to UTF-16LE, as expected by many Windows tools.
+type utf16Writer struct {
+	w       io.Writer
+	partial []byte // incomplete UTF-8 sequence from the previous Write
+}
+
+// Write converts p to UTF-16LE and writes it. A UTF-8 sequence split across
+// calls is held until the rest of the sequence is written.
+func (u *utf16Writer) Write(p []byte) (int, error) {
+	b := append(u.partial, p...)
+
+	out := make([]byte, 0, 2*len(b))
+	for len(b) > 0 {
+		if !utf8.FullRune(b) {
+			break
+		}
+		r, size := utf8.DecodeRune(b)
+		b = b[size:]
+
+		if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+			out = append(out, byte(r1), byte(r1>>8), byte(r2), byte(r2>>8))
+		} else {
+			out = append(out, byte(r), byte(r>>8))
+		}
+	}
+	u.partial = append(u.partial[:0:0], b...)
+
+	if _, err := u.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes U+FFFD for an incomplete UTF-8 sequence held from the
+// previous Write, since it cannot be completed once the output ends.
+func (u *utf16Writer) Flush() error {
+	if len(u.partial) == 0 {
+		return nil
+	}
+	u.partial = nil
+	_, err := u.w.Write([]byte{0xFD, 0xFF})
+	return err
+}
+
+// flushEncoding writes what w, returned by encodeOutput, holds back at the
+// end of the output.
+func flushEncoding(w io.Writer) error {
+	if u, ok := w.(*utf16Writer); ok {
+		return u.Flush()
+	}
+	return nil
+}
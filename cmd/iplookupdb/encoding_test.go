@@ -0,0 +1,37 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUTF16Writer(t *testing.T) {
+	tests := []struct {
+		writes []string
+		want   []byte
+	}{
+		{[]string{"a€"}, []byte{'a', 0, 0xAC, 0x20}},
+		{[]string{"a\xe2", "\x82\xac"}, []byte{'a', 0, 0xAC, 0x20}}, // € split across writes
+		{[]string{"😀"}, []byte{0x3D, 0xD8, 0x00, 0xDE}},
+		{[]string{"a\xe2\x82"}, []byte{'a', 0, 0xFD, 0xFF}}, // incomplete at the end
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		u := &utf16Writer{w: &b}
+		for _, s := range tt.writes {
+			if n, err := u.Write([]byte(s)); err != nil || n != len(s) {
+				t.Fatalf("Write(%q) = %d, %v, want %d", s, n, err, len(s))
+			}
+		}
+		if err := flushEncoding(u); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.Bytes(), tt.want) {
+			t.Errorf("%q written as % x, want % x", tt.writes, b.Bytes(), tt.want)
+		}
+	}
+}
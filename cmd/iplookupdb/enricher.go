@@ -0,0 +1,181 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+)
+
+// enricher is a stage that adds fields to each record after the lookup and
+// before the script, such as the owner of an IP from an internal asset db.
+type enricher interface {
+	// addedColumns returns the names of the fields added by enrich that are
+	// output as columns.
+	addedColumns() []string
+
+	// enrich adds fields to the record e. Since records are enriched
+	// concurrently, enrich must be safe for concurrent use.
+	enrich(e env) error
+}
+
+// opener is an enricher that reads a database or file, such as -asn-db. It is
+// opened after the flags are parsed, rather than by parseFlags, so a missing
+// or invalid file is reported as a database error rather than a usage error.
+type opener interface {
+	enricher
+
+	// open reads the database or file.
+	open() error
+}
+
+// openEnrichers opens the openers in the chain of enrichers. If one cannot be
+// opened, those already opened are closed.
+func openEnrichers(chain []enricher) error {
+	for _, x := range chain {
+		if o, ok := x.(opener); ok {
+			if err := o.open(); err != nil {
+				closeEnrichers(chain)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// closeEnrichers closes the enrichers in the chain that have a Close method,
+// such as those with a database.
+func closeEnrichers(chain []enricher) {
+	for _, x := range chain {
+		if c, ok := x.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// registeredEnricher is an enricher registered with lookup.RegisterEnricher.
+type registeredEnricher struct {
+	lookup.Enricher
+}
+
+func (x registeredEnricher) addedColumns() []string {
+	return x.Columns()
+}
+
+func (x registeredEnricher) enrich(e env) error {
+	return x.Enrich(e)
+}
+
+// lookupEnrichers returns the enrichers registered with
+// lookup.RegisterEnricher with the given names, in order. The columns they
+// add must not be one of the fields.
+func lookupEnrichers(names []string, fields []string) ([]enricher, []string, error) {
+	fields = slices.Clone(fields)
+
+	var chain []enricher
+	for _, name := range names {
+		registered := lookup.RegisteredEnricher(name)
+		if registered == nil {
+			return nil, nil, fmt.Errorf("unknown enricher %q, available: %v", name, lookup.EnricherNames())
+		}
+		e := registeredEnricher{registered}
+		for _, col := range e.addedColumns() {
+			if slices.Contains(fields, col) {
+				return nil, nil, fmt.Errorf("%s: column %q is already a field", name, col)
+			}
+			fields = append(fields, col)
+		}
+		chain = append(chain, e)
+	}
+
+	return chain, fields, nil
+}
+
+// pendingRecord is a record waiting for its lookup or enrichment to
+// complete.
+type pendingRecord struct {
+	done chan struct{}
+	emit func() // outputs the record once done
+}
+
+// async runs work, if not nil, in the background and calls emit once it
+// completes. Records are emitted in the order they were started, and at most
+// the larger of the -workers and -exec-concurrency limits are in progress.
+func (p *processor) async(work, emit func()) {
+	r := &pendingRecord{done: make(chan struct{}), emit: emit}
+	if work == nil {
+		close(r.done)
+	} else {
+		go func() {
+			work()
+			close(r.done)
+		}()
+	}
+
+	p.pending = append(p.pending, r)
+	for len(p.pending) >= max(p.cfg.enrichLimit, p.cfg.workers) {
+		p.emitPending()
+	}
+}
+
+// enrich starts running the enrichers, if any, on the record e and calls
+// emit once they complete. Records are emitted in the order they were
+// enriched. If e is nil, the record is not enriched, but is still emitted in
+// order. Errors are reported and the record is emitted with the enrichment
+// done before the error.
+func (p *processor) enrich(e env, emit func()) {
+	switch {
+	case len(p.cfg.enrichers) == 0 && len(p.pending) == 0:
+		emit()
+	case len(p.cfg.enrichers) == 0 || e == nil:
+		p.async(nil, emit)
+	default:
+		var err error
+		p.async(func() { err = p.runEnrichers(e) }, func() {
+			p.reportEnrichError(e, err)
+			emit()
+		})
+	}
+}
+
+// runEnrichers runs the enrichers on the record e in order, stopping at the
+// first error.
+func (p *processor) runEnrichers(e env) error {
+	for _, x := range p.cfg.enrichers {
+		if err := x.enrich(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportEnrichError reports err, if not nil, from enriching the record e.
+func (p *processor) reportEnrichError(e env, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error enriching IP %v: %v\n", e["ip"], err)
+	}
+}
+
+// emitPending waits for the oldest pending record to be done and emits it.
+func (p *processor) emitPending() {
+	r := p.pending[0]
+	p.pending = p.pending[1:]
+
+	<-r.done
+	r.emit()
+}
+
+// drain emits all pending records and flushes the output.
+func (p *processor) drain() {
+	for len(p.pending) > 0 {
+		p.emitPending()
+	}
+	if err := p.rw.flush(); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing output:", err)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+)
+
+// Defaults for the fields of events used by -events.
+const (
+	defaultEventIP     = "ip"  // field containing the IP
+	defaultEventTarget = "geo" // field the location is added to
+)
+
+// eventIP returns the string at path in event, where path is a dot-separated
+// list of field names, such as source.ip, to select nested fields.
+func eventIP(event map[string]json.RawMessage, path string) (string, bool) {
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		var nested map[string]json.RawMessage
+		if err := json.Unmarshal(event[name], &nested); err != nil || nested == nil {
+			return "", false
+		}
+		event = nested
+	}
+
+	var s string
+	if err := json.Unmarshal(event[names[len(names)-1]], &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// processEvent adds the location of the IP in the JSON event in line, such
+// as from a log shipper, as an object in the target field and writes the
+// event. Fields of the event are passed through unchanged. Events without
+// an IP, or that are not valid JSON, are written unchanged.
+func (p *processor) processEvent(line string) {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &event); err != nil || event == nil {
+		p.invalid++
+		fmt.Fprintf(os.Stderr, "Invalid event %q: not a JSON object\n", strings.TrimSpace(line))
+		p.writeLine(line)
+		return
+	}
+
+	var e env
+	if ipStr, found := eventIP(event, p.cfg.eventIP); found {
+		addr, port, err := lookup.ParseIP(ipStr)
+		if err != nil {
+			p.invalid++
+			if !p.cfg.skipInvalid {
+				fmt.Fprintf(os.Stderr, "Cannot convert %q to IP\n", ipStr)
+			}
+		} else {
+			if p.unique != nil {
+				p.unique[addr.Unmap()] = struct{}{}
+			}
+			loc, ok, err := p.locate(addr.Unmap())
+			if err != nil {
+				p.lookupErrors++
+				fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", addr.Unmap(), err)
+			} else if ok {
+				e = recordEnv(outputAddr(addr, p.cfg.keepMapped), port, loc)
+			}
+		}
+	}
+
+	p.enrich(e, func() { p.outputEvent(line, event, e) })
+}
+
+// outputEvent writes the event with the record e added, if any, when the
+// record matches the filter.
+func (p *processor) outputEvent(line string, event map[string]json.RawMessage, e env) {
+	if e == nil {
+		if p.match(e) {
+			p.writeLine(line)
+		}
+		return
+	}
+
+	if !p.runScript(e) {
+		return
+	}
+	if _, ok := p.evalRecord(e); !ok {
+		return
+	}
+
+	p.observe(e)
+	geo := make(map[string]any)
+	names := append([]string{"city", "subdivision", "country", "country_iso", "is_private"},
+		p.extraColumns()...)
+	for _, name := range names {
+		if v := e[name]; v != nil && v != "" {
+			geo[name] = v
+		}
+	}
+
+	b, err := json.Marshal(geo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", e["ip"], err)
+		p.writeLine(line)
+		return
+	}
+	event[p.cfg.eventTarget] = b
+
+	b, err = json.Marshal(event)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", e["ip"], err)
+		p.writeLine(line)
+		return
+	}
+	p.writeLine(string(b))
+}
+
+// extraColumns returns the names of the computed columns and the columns
+// added by the enrichers and the script.
+func (p *processor) extraColumns() []string {
+	var names []string
+	for _, c := range p.cfg.computed {
+		names = append(names, c.name)
+	}
+	for _, x := range p.cfg.enrichers {
+		names = append(names, x.addedColumns()...)
+	}
+	if p.cfg.script != nil {
+		names = append(names, p.cfg.script.columns...)
+	}
+	return names
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// execEnricher enriches records using an external command, which allows
+// plugins to be written in any language.
+//
+// The command is run by the shell for each record, with the record written
+// to its stdin as a JSON object. The command writes a JSON object to stdout
+// whose fields are merged into the record.
+type execEnricher struct {
+	command string
+	columns []string // fields added by the command that are output
+}
+
+// newExecEnricher returns an enricher running command. The columns, given as
+// a comma-separated list, must not be one of the existing record fields.
+func newExecEnricher(command, columns string, fields []string) (*execEnricher, error) {
+	x := &execEnricher{command: command}
+	if columns != "" {
+		for _, col := range strings.Split(columns, ",") {
+			col = strings.TrimSpace(col)
+			if !isIdent(col) {
+				return nil, fmt.Errorf("%q is not a valid column name", col)
+			}
+			if slices.Contains(fields, col) || slices.Contains(x.columns, col) {
+				return nil, fmt.Errorf("column %q is already a field", col)
+			}
+			x.columns = append(x.columns, col)
+		}
+	}
+	return x, nil
+}
+
+func (x *execEnricher) addedColumns() []string {
+	return x.columns
+}
+
+// shellCommand returns the command to run s with the shell.
+func shellCommand(s string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", s)
+	}
+	return exec.Command("/bin/sh", "-c", s)
+}
+
+// enrich runs the command for the record e and merges the fields of its
+// response into e. Anything written by the command to stderr is passed
+// through.
+func (x *execEnricher) enrich(e env) error {
+	in, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	cmd := shellCommand(x.command)
+	cmd.Stdin = bytes.NewReader(append(in, '\n'))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(out, &fields); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+
+	for k, v := range fields {
+		switch v.(type) {
+		case nil, string, float64, bool:
+		default:
+			// nested objects and arrays are kept as JSON
+			b, _ := json.Marshal(v)
+			v = string(b)
+		}
+		e[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Exit statuses of the program. Statuses 1 to 4 are fatal errors that stop
+// processing, and status 5 is for errors in some of the inputs.
+const (
+	exitOK           = 0 // all inputs were looked up
+	exitUsage        = 1 // invalid options
+	exitDB           = 2 // a database could not be opened or is stale
+	exitInput        = 3 // the input could not be read
+	exitOutput       = 4 // the output could not be written
+	exitLookupErrors = 5 // an input was invalid or a lookup failed
+)
+
+// failed reports whether an input was invalid, unless invalid inputs are
+// skipped, or a lookup failed.
+func (p *processor) failed() bool {
+	return p.lookupErrors > 0 || p.invalid > 0 && !p.cfg.skipInvalid
+}
+
+// printErrorSummary writes the number of invalid inputs and failed lookups
+// to stderr.
+func (p *processor) printErrorSummary() {
+	fmt.Fprintf(os.Stderr, "Errors: %d invalid inputs, %d failed lookups\n", p.invalid, p.lookupErrors)
+}
@@ -0,0 +1,618 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements a small expression language used by -filter to select
+// records and by -compute to add columns. An expression compares record fields
+// with literals or computes a value, such as
+//
+//	country_iso == "RU" or (is_private and not city == "")
+//	is_private ? "internal" : country_iso + "/" + city
+//
+// The operators, from lowest to highest precedence, are the conditional
+// operator (?:), or (||), and (&&), not (!), the comparisons ==, !=, <, <=, >,
+// >=, and =~, which matches a regular expression, + and -, *, /, and %, and
+// unary minus. A field by itself is true if it is a true bool, a non-empty
+// string, or a non-zero number. The + operator concatenates if either value
+// is a string that is not a number.
+
+// env holds the values of the fields of a record, which are a string,
+// float64, or bool.
+type env map[string]any
+
+// expr is a parsed expression.
+type expr interface {
+	eval(e env) (any, error)
+}
+
+// parseExpr parses s as an expression. Identifiers must be in fields.
+func parseExpr(s string, fields []string) (expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens, fields: fields}
+	x, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+	}
+	return x, nil
+}
+
+// truth returns whether v is considered true.
+func truth(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	}
+	return false
+}
+
+// token kinds
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind int
+	text string // the operator, identifier, or unquoted string
+	num  float64
+	pos  int
+}
+
+// operators are listed with longer operators first so they match first.
+var operators = []string{
+	"==", "!=", "<=", ">=", "=~", "&&", "||",
+	"<", ">", "!", "(", ")", "?", ":", "+", "-", "*", "/", "%",
+}
+
+// lex splits s into tokens.
+func lex(s string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(s) && s[end] != s[i] {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			text, err := unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d: %w", i, err)
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: i})
+			i = end + 1
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			end := i + 1
+			for end < len(s) && strings.ContainsRune("0123456789.eE", rune(s[end])) {
+				end++
+			}
+			n, err := strconv.ParseFloat(s[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", s[i:end], i)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: s[i:end], num: n, pos: i})
+			i = end
+
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(s) && (s[end] == '_' || unicode.IsLetter(rune(s[end])) || unicode.IsDigit(rune(s[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: s[i:end], pos: i})
+			i = end
+
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, text: "end of expression", pos: len(s)}), nil
+}
+
+// unquote returns the string literal s without quotes. Single-quoted strings
+// are treated the same as double-quoted strings.
+func unquote(s string) (string, error) {
+	if s[0] == '\'' {
+		s = `"` + strings.ReplaceAll(s[1:len(s)-1], `"`, `\"`) + `"`
+	}
+	return strconv.Unquote(s)
+}
+
+// exprParser is a recursive descent parser for expressions.
+type exprParser struct {
+	tokens []token
+	pos    int
+	fields []string
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token and returns true if it is one of the
+// operators or keywords in ops.
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp && t.kind != tokIdent {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) parseCond() (expr, error) {
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+
+	x, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept(":"); !ok {
+		return nil, fmt.Errorf("missing : at offset %d", p.peek().pos)
+	}
+	y, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	return &condExpr{cond, x, y}, nil
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("or", "||"); !ok {
+			return x, nil
+		}
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &logicalExpr{or: true, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("and", "&&"); !ok {
+			return x, nil
+		}
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = &logicalExpr{or: false, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseNot() (expr, error) {
+	if _, ok := p.accept("not", "!"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (expr, error) {
+	x, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "=~")
+	if !ok {
+		return x, nil
+	}
+
+	if op == "=~" {
+		t := p.next()
+		if t.kind != tokString {
+			return nil, fmt.Errorf("=~ requires a string at offset %d", t.pos)
+		}
+		re, err := regexp.Compile(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return &matchExpr{x, re}, nil
+	}
+
+	y, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	return &compareExpr{op, x, y}, nil
+}
+
+func (p *exprParser) parseSum() (expr, error) {
+	x, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return x, nil
+		}
+		y, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		x = &arithExpr{op, x, y}
+	}
+}
+
+func (p *exprParser) parseProduct() (expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return x, nil
+		}
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &arithExpr{op, x, y}
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if _, ok := p.accept("-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &arithExpr{"-", literal{0.0}, x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return literal{t.text}, nil
+	case tokNumber:
+		return literal{t.num}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		}
+		if !slices.Contains(p.fields, t.text) {
+			return nil, fmt.Errorf("unknown field %q, valid fields are: %s",
+				t.text, strings.Join(p.fields, ", "))
+		}
+		return field(t.text), nil
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parseCond()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, fmt.Errorf("missing ) at offset %d", p.peek().pos)
+			}
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+}
+
+// literal is a constant value.
+type literal struct {
+	v any
+}
+
+func (l literal) eval(env) (any, error) {
+	return l.v, nil
+}
+
+// field is the value of a record field.
+type field string
+
+func (f field) eval(e env) (any, error) {
+	return e[string(f)], nil
+}
+
+// logicalExpr is x or y, or x and y, evaluated with short-circuiting.
+type logicalExpr struct {
+	or   bool
+	x, y expr
+}
+
+func (l *logicalExpr) eval(e env) (any, error) {
+	x, err := l.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if truth(x) == l.or {
+		return l.or, nil
+	}
+	y, err := l.y.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return truth(y), nil
+}
+
+// condExpr is cond ? x : y.
+type condExpr struct {
+	cond, x, y expr
+}
+
+func (c *condExpr) eval(e env) (any, error) {
+	cond, err := c.cond.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if truth(cond) {
+		return c.x.eval(e)
+	}
+	return c.y.eval(e)
+}
+
+// arithExpr is x op y, where op is +, -, *, /, or %.
+type arithExpr struct {
+	op   string
+	x, y expr
+}
+
+func (a *arithExpr) eval(e env) (any, error) {
+	x, err := a.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	y, err := a.y.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	xn, xok := toNumber(x)
+	yn, yok := toNumber(y)
+	if a.op == "+" && (!xok || !yok) {
+		_, xs := x.(string)
+		_, ys := y.(string)
+		if xs || ys {
+			return formatValue(x) + formatValue(y), nil
+		}
+	}
+	if !xok || !yok {
+		return nil, fmt.Errorf("cannot compute %v %s %v", formatValue(x), a.op, formatValue(y))
+	}
+
+	switch a.op {
+	case "+":
+		return xn + yn, nil
+	case "-":
+		return xn - yn, nil
+	case "*":
+		return xn * yn, nil
+	}
+	if yn == 0 {
+		return nil, errors.New("division by zero")
+	}
+	if a.op == "/" {
+		return xn / yn, nil
+	}
+	return math.Mod(xn, yn), nil
+}
+
+// formatValue returns v formatted for output. Numbers use the fewest digits
+// needed and a missing value is empty.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// notExpr is not x.
+type notExpr struct {
+	x expr
+}
+
+func (n *notExpr) eval(e env) (any, error) {
+	x, err := n.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return !truth(x), nil
+}
+
+// matchExpr is x =~ re.
+type matchExpr struct {
+	x  expr
+	re *regexp.Regexp
+}
+
+func (m *matchExpr) eval(e env) (any, error) {
+	x, err := m.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return m.re.MatchString(fmt.Sprint(x)), nil
+}
+
+// compareExpr is x op y.
+type compareExpr struct {
+	op   string
+	x, y expr
+}
+
+var errCompare = errors.New("cannot compare")
+
+func (c *compareExpr) eval(e env) (any, error) {
+	x, err := c.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	y, err := c.y.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	// values that cannot be compared, such as a missing port and a number,
+	// are not equal
+	cmp, err := compare(x, y)
+	if err != nil {
+		return c.op == "!=", nil
+	}
+
+	switch c.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default: // >=
+		return cmp >= 0, nil
+	}
+}
+
+// compare returns -1, 0, or 1 if x is less than, equal to, or greater than y.
+// Numbers are compared numerically, converting a string to a number if
+// needed, strings are compared lexically, and bools are only equal or not.
+func compare(x, y any) (int, error) {
+	switch xv := x.(type) {
+	case float64:
+		yv, ok := toNumber(y)
+		if !ok {
+			return 0, errCompare
+		}
+		return cmpNumber(xv, yv), nil
+	case string:
+		if yv, ok := y.(float64); ok {
+			xn, ok := toNumber(xv)
+			if !ok {
+				return 0, errCompare
+			}
+			return cmpNumber(xn, yv), nil
+		}
+		if yv, ok := y.(string); ok {
+			return strings.Compare(xv, yv), nil
+		}
+	case bool:
+		if yv, ok := y.(bool); ok {
+			if xv == yv {
+				return 0, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, errCompare
+}
+
+func toNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func cmpNumber(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
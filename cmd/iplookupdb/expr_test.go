@@ -0,0 +1,128 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	e := env{
+		"ip":          "81.2.69.142",
+		"port":        "443",
+		"city":        "London",
+		"country_iso": "GB",
+		"is_private":  false,
+		"latitude":    51.5142,
+		"asn":         nil,
+	}
+
+	tests := []struct {
+		in   string
+		want any
+	}{
+		{`1 + 2 * 3`, 7.0},
+		{`(1 + 2) * 3`, 9.0},
+		{`-2 - -3`, 1.0},
+		{`7 % 4 / 2`, 1.5},
+		{`country_iso == "GB"`, true},
+		{`country_iso != 'GB'`, false},
+		{`port > 80`, true},
+		{`port == 443 && latitude >= 51`, true},
+		{`city < "Paris"`, true},
+		{`asn == 0`, false},
+		{`asn != 0`, true},
+		{`is_private || city =~ "^Lon"`, true},
+		{`!is_private && !(city =~ "^Par")`, true},
+		{`country_iso == "GB" ? "uk" : "other"`, "uk"},
+		{`is_private ? "a" : city == "" ? "b" : "c"`, "c"},
+		{`city + ", " + country_iso`, "London, GB"},
+		{`"port " + port`, "port 443"},
+		{`port + 1`, 444.0},
+		{`true && false || true`, true},
+		{`.5 * 4`, 2.0},
+	}
+
+	for _, tt := range tests {
+		x, err := parseExpr(tt.in, filterFields)
+		if err != nil {
+			t.Errorf("parseExpr(%s) error: %v", tt.in, err)
+			continue
+		}
+		got, err := x.eval(e)
+		if err != nil {
+			t.Errorf("eval(%s) error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("eval(%s) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExprEvalError(t *testing.T) {
+	e := env{"city": "London", "latitude": 51.5142}
+
+	for _, in := range []string{`1 / 0`, `5 % 0`, `city * 2`, `latitude - "x"`} {
+		x, err := parseExpr(in, filterFields)
+		if err != nil {
+			t.Errorf("parseExpr(%s) error: %v", in, err)
+			continue
+		}
+		if got, err := x.eval(e); err == nil {
+			t.Errorf("eval(%s) = %#v, want error", in, got)
+		}
+	}
+}
+
+func TestParseExprError(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // in the error
+	}{
+		{`bogus == 1`, `unknown field "bogus"`},
+		{`city ==`, `unexpected "end of expression"`},
+		{`(1 + 2`, `missing )`},
+		{`1 2`, `unexpected "2" at offset 2`},
+		{`city == "London`, `unterminated string`},
+		{`city # 1`, `unexpected '#' at offset 5`},
+		{`1.2.3`, `invalid number "1.2.3"`},
+		{`is_private ? 1`, `:`},
+		{`city =~ "("`, `(`},
+		{``, `unexpected "end of expression"`},
+	}
+
+	for _, tt := range tests {
+		_, err := parseExpr(tt.in, filterFields)
+		if err == nil {
+			t.Errorf("parseExpr(%s) succeeded, want error", tt.in)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseExpr(%s) error %q, want it to contain %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestTruth(t *testing.T) {
+	tests := []struct {
+		v    any
+		want bool
+	}{
+		{true, true},
+		{false, false},
+		{"x", true},
+		{"", false},
+		{1.0, true},
+		{0.0, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := truth(tt.v); got != tt.want {
+			t.Errorf("truth(%#v) = %t, want %t", tt.v, got, tt.want)
+		}
+	}
+}
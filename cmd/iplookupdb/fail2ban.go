@@ -0,0 +1,183 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// fail2banTimeout is the maximum time to wait for the -server before
+// falling back to the database.
+const fail2banTimeout = 2 * time.Second
+
+// banSummary is the location of a banned IP.
+type banSummary struct {
+	Time        time.Time `json:"time"`
+	Jail        string    `json:"jail,omitempty"`
+	IP          string    `json:"ip"`
+	City        string    `json:"city"`
+	Subdivision string    `json:"subdivision"`
+	Country     string    `json:"country"`
+	CountryISO  string    `json:"country_iso"`
+}
+
+// String returns the compact single-line summary of b.
+func (b banSummary) String() string {
+	var sb strings.Builder
+	if b.Jail != "" {
+		fmt.Fprintf(&sb, "[%s] ", b.Jail)
+	}
+	fmt.Fprintf(&sb, "%s %s/%s/%s", b.IP, b.CountryISO, b.Subdivision, b.City)
+	return sb.String()
+}
+
+// runFail2ban runs the fail2ban subcommand, which is designed to be invoked
+// from a fail2ban action with the banned IP. It writes a single-line summary
+// of the location of the IP, or JSON, to stdout, and optionally appends it
+// with the time to a ban log. The IP is looked up by a running -serve
+// instance, if given, to avoid opening the database for each ban, or in the
+// database if the instance cannot be reached.
+func runFail2ban(args []string) int {
+	fs := flag.NewFlagSet("fail2ban", flag.ContinueOnError)
+	dbName := fs.String("db", "GeoLite2-City.mmdb", "Path to the GeoLite2 City database")
+	lang := fs.String("lang", "en", "Language for GeoIP lookup results.")
+	jail := fs.String("jail", "", "Name of the fail2ban jail, included in the summary.")
+	asJSON := fs.Bool("json", false, "Write the summary as JSON.")
+	logName := fs.String("log", "", "Ban log file to append the summary to.")
+	server := fs.String("server", "", "Address of a running iplookupdb -serve to look up the IP, e.g., localhost:8080.")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: iplookupdb fail2ban [flags] ip")
+		return exitUsage
+	}
+
+	addr, _, err := lookup.ParseIP(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot convert %q to IP\n", fs.Arg(0))
+		return exitInput
+	}
+
+	var loc location
+	if *server != "" {
+		loc, err = serverLocation(*server, addr.Unmap())
+	}
+	if *server == "" || err != nil {
+		db, err := geoip2.Open(*dbName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+			return exitDB
+		}
+		defer db.Close()
+
+		cfg := config{lang: *lang, private: privateLabel}
+		p := newProcessor(io.Discard, cfg, db, nil)
+		loc, _, err = p.locate(addr.Unmap())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", addr.Unmap(), err)
+			return exitDB
+		}
+	}
+
+	b := banSummary{
+		Time:        time.Now().UTC().Truncate(time.Second),
+		Jail:        *jail,
+		IP:          addr.Unmap().String(),
+		City:        loc.city,
+		Subdivision: loc.subdivision,
+		Country:     loc.country,
+		CountryISO:  loc.countryISO,
+	}
+	for _, s := range []*string{&b.City, &b.Subdivision, &b.Country, &b.CountryISO} {
+		if *s == "" {
+			*s = "unknown"
+		}
+	}
+
+	line := b.String()
+	if *asJSON {
+		j, err := json.Marshal(b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write output: %v\n", err)
+			return exitOutput
+		}
+		line = string(j)
+	}
+	fmt.Println(line)
+
+	if *logName != "" {
+		if !*asJSON {
+			line = b.Time.Format(time.RFC3339) + " " + line
+		}
+		if err := appendLine(*logName, line); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write ban log: %v\n", err)
+			return exitOutput
+		}
+	}
+	return exitOK
+}
+
+// serverLocation returns the location of ip looked up by the iplookupdb
+// -serve instance at addr, such as localhost:8080, which must output the
+// city, subdivision, country, and country_iso fields.
+func serverLocation(addr string, ip netip.Addr) (location, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	client := &http.Client{Timeout: fail2banTimeout}
+	resp, err := client.Get(strings.TrimSuffix(addr, "/") + "/lookup/" + url.PathEscape(ip.String()))
+	if err != nil {
+		return location{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return location{}, fmt.Errorf("server: %s", resp.Status)
+	}
+
+	var r struct {
+		City        *string `json:"city"`
+		Subdivision *string `json:"subdivision"`
+		Country     *string `json:"country"`
+		CountryISO  *string `json:"country_iso"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return location{}, fmt.Errorf("server: %w", err)
+	}
+	if r.City == nil || r.Subdivision == nil || r.Country == nil || r.CountryISO == nil {
+		return location{}, errors.New("server: missing city, subdivision, country, or country_iso field")
+	}
+	return location{
+		city:        *r.City,
+		subdivision: *r.Subdivision,
+		country:     *r.Country,
+		countryISO:  *r.CountryISO,
+	}, nil
+}
+
+// appendLine appends line to the file name, creating it if needed.
+func appendLine(name, line string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// fallbackDB is a database after the first of -db, which is tried in order
+// for IPs that have no record in the databases before it.
+type fallbackDB struct {
+	name   string // base name of the file, output as source_db
+	db     geoDB
+	dbType string
+}
+
+// openFallbacks opens the fallback databases names, which must be of
+// dbType, the type of the first database, or Country databases after a City
+// database, and have the languages in lang.
+func openFallbacks(names []string, dbType, lang string, v verbose) ([]fallbackDB, error) {
+	var fallbacks []fallbackDB
+	for _, name := range names {
+		db, err := geoip2.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		v.metadata(name, db)
+
+		t, err := detectDBType(db.Metadata().DatabaseType)
+		if err == nil && t != dbType && !(dbType == dbTypeCity && t == dbTypeCountry) {
+			err = fmt.Errorf("%s has type %s, which cannot follow a database of type %s", name, t, dbType)
+		}
+		if err == nil {
+			err = validateLang(db, lang)
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		fallbacks = append(fallbacks, fallbackDB{filepath.Base(name), db, t})
+	}
+	return fallbacks, nil
+}
+
+// closeFallbacks closes the fallback databases.
+func closeFallbacks(fallbacks []fallbackDB) {
+	for _, f := range fallbacks {
+		if c, ok := f.db.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// fallbackLocation returns the location of ip in the first fallback db
+// with a record for it, and reports whether one did.
+func (p *processor) fallbackLocation(ip net.IP) (location, bool, error) {
+	for _, f := range p.cfg.fallbacks {
+		loc, err := dbTypeLocation(f.db, f.dbType, ip, p.cfg.lang)
+		if err != nil {
+			return location{}, false, err
+		}
+		if !loc.empty() {
+			loc.sourceDB = f.name
+			return loc, true, nil
+		}
+	}
+	return location{}, false, nil
+}
+
+// empty reports whether loc has none of the fields of a db record, such as
+// when the IP is not in the db.
+func (loc location) empty() bool {
+	return loc.country == "" && loc.countryISO == "" && loc.city == "" &&
+		!loc.hasCoords && loc.asn == "" && loc.isp == "" &&
+		loc.organization == "" && loc.domain == "" &&
+		loc.anonymous == anonymousFlags{}
+}
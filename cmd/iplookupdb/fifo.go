@@ -0,0 +1,37 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+)
+
+// isNamedPipe reports whether name is a named pipe (FIFO).
+func isNamedPipe(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// readPipe processes lines from the named pipe name. When all writers close
+// the pipe, which is seen as the end of input, the pipe is reopened to wait
+// for the next writer, until interrupted or the maximum number of records
+// is processed.
+func (p *processor) readPipe(name string) error {
+	for !p.maxReached() {
+		// blocks until there is a writer
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+
+		err = p.processIPsFromInput(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		p.drain()
+		p.cfg.verbose.printf("%s was closed by its writers, reopening", name)
+	}
+	return nil
+}
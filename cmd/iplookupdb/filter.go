@@ -0,0 +1,129 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// filterFields are the record fields available to -filter expressions.
+var filterFields = []string{
+	"ip", "port", "city", "subdivision", "country", "country_iso", "is_private",
+	"continent", "continent_code", "postal", "timezone", "latitude", "longitude",
+	"accuracy_radius",
+	"asn", "as_org", "isp", "organization", "domain", "is_anonymous",
+	"is_anonymous_vpn", "is_hosting_provider", "is_public_proxy",
+	"is_residential_proxy", "is_tor_exit_node", "source_db", "scope",
+}
+
+// recordEnv returns the fields of the record for addr, with the optional
+// port, at loc.
+func recordEnv(addr netip.Addr, port string, loc location) env {
+	e := env{
+		"ip":             addr.String(),
+		"port":           port,
+		"city":           loc.city,
+		"subdivision":    loc.subdivision,
+		"country":        loc.country,
+		"country_iso":    loc.countryISO,
+		"is_private":     addr.Unmap().IsPrivate(),
+		"scope":          ipScope(addr.Unmap()),
+		"continent":      loc.continent,
+		"continent_code": loc.continentCode,
+		"postal":         loc.postal,
+		"timezone":       loc.timezone,
+		"source_db":      loc.sourceDB,
+
+		// coordinates are empty if unknown rather than 0, which is a place
+		"latitude":        nil,
+		"longitude":       nil,
+		"accuracy_radius": nil,
+	}
+	if loc.hasCoords {
+		e["latitude"], e["longitude"] = loc.latitude, loc.longitude
+		e["accuracy_radius"] = float64(loc.accuracyRadius)
+	}
+	addTypeFields(e, loc)
+	return e
+}
+
+// computedColumn is an output column computed by -compute.
+type computedColumn struct {
+	name string
+	x    expr
+}
+
+// parseComputed parses defs, each of the form name=expression, as computed
+// columns. Expressions may use the fields and any earlier computed columns.
+func parseComputed(defs []string, fields []string) ([]computedColumn, []string, error) {
+	fields = slices.Clone(fields)
+
+	var columns []computedColumn
+	for _, def := range defs {
+		name, s, found := strings.Cut(def, "=")
+		name = strings.TrimSpace(name)
+		if !found || !isIdent(name) {
+			return nil, nil, fmt.Errorf("%q is not of the form name=expression", def)
+		}
+		if slices.Contains(fields, name) {
+			return nil, nil, fmt.Errorf("%q is already a field", name)
+		}
+
+		x, err := parseExpr(s, fields)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		columns = append(columns, computedColumn{name, x})
+		fields = append(fields, name)
+	}
+
+	return columns, fields, nil
+}
+
+// isIdent reports whether s is a valid field name.
+func isIdent(s string) bool {
+	for n, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (n == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// evalRecord adds the computed columns to the record e and returns their
+// formatted values, and reports whether the record matches the filter.
+// Errors computing a column are reported and the value is empty.
+func (p *processor) evalRecord(e env) (computed []string, ok bool) {
+	for _, c := range p.cfg.computed {
+		v, err := c.x.eval(e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error computing %s for IP %v: %v\n", c.name, e["ip"], err)
+		}
+		e[c.name] = v
+		computed = append(computed, formatValue(v))
+	}
+	return computed, p.match(e)
+}
+
+// match reports whether the record e matches the filter. If there is no
+// filter, every record matches. Errors evaluating the filter are reported
+// and the record does not match.
+func (p *processor) match(e env) bool {
+	if p.cfg.filter == nil {
+		return true
+	}
+
+	v, err := p.cfg.filter.eval(e)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error filtering IP %v: %v\n", e["ip"], err)
+		return false
+	}
+	return truth(v)
+}
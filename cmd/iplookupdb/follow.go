@@ -0,0 +1,226 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// followInterval is how often followed files are checked for new lines.
+const followInterval = 250 * time.Millisecond
+
+// followedFile is an input file being followed.
+type followedFile struct {
+	path    string
+	f       *os.File
+	info    os.FileInfo // of f, used to detect rotation
+	offset  int64       // bytes of f read
+	partial []byte      // incomplete last line
+}
+
+// openFollowed opens the file path to follow. If atEnd is set, existing
+// lines are skipped.
+func openFollowed(path string, atEnd bool) (*followedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ff := &followedFile{path: path, f: f, info: info}
+	if atEnd {
+		ff.offset, err = f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return ff, nil
+}
+
+// readLines returns the complete lines added to the file since the last
+// read. An incomplete last line is kept until it is completed.
+func (ff *followedFile) readLines() ([]string, error) {
+	b, err := io.ReadAll(ff.f)
+	ff.offset += int64(len(b))
+	if len(b) == 0 {
+		return nil, err
+	}
+
+	b = append(ff.partial, b...)
+	end := bytes.LastIndexByte(b, '\n')
+	ff.partial = bytes.Clone(b[end+1:])
+
+	var lines []string
+	if end >= 0 {
+		for _, line := range bytes.Split(b[:end], []byte("\n")) {
+			lines = append(lines, string(bytes.TrimSuffix(line, []byte("\r"))))
+		}
+	}
+	return lines, err
+}
+
+// rotated checks whether the file at path was replaced, such as by
+// logrotate renaming it and creating a new file, or truncated, such as by
+// logrotate copytruncate. A replaced file is reopened from the start, and a
+// truncated file is read again from the start.
+func (ff *followedFile) rotated() (string, error) {
+	info, err := os.Stat(ff.path)
+	if err != nil {
+		// renamed and not yet recreated, keep reading the old file
+		return "", nil
+	}
+
+	if !os.SameFile(info, ff.info) {
+		nf, err := openFollowed(ff.path, false)
+		if err != nil {
+			return "", err
+		}
+		ff.f.Close()
+		*ff = *nf
+		return "rotated", nil
+	}
+
+	if info.Size() < ff.offset {
+		if _, err := ff.f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		ff.offset = 0
+		ff.partial = nil
+		return "truncated", nil
+	}
+
+	return "", nil
+}
+
+// follow processes lines as they are added to the files matching pattern,
+// like tail -F, until the maximum number of records is reached or the
+// program is interrupted.
+//
+// The pattern may be a file name or a glob, such as /var/log/nginx/*.log,
+// which is checked for new files that are then read from the start. Files
+// that exist when following starts are read from their end. Rotated files,
+// whether renamed and recreated or truncated in place, are followed
+// without losing lines written before rotation.
+func (p *processor) follow(pattern string) error {
+	files := make(map[string]*followedFile)
+	defer func() {
+		for _, ff := range files {
+			ff.f.Close()
+		}
+	}()
+	v := p.cfg.verbose
+
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 && !hasMeta(pattern) {
+		return fmt.Errorf("%s: %w", pattern, os.ErrNotExist)
+	}
+	for _, path := range paths {
+		ff, err := openFollowed(path, true)
+		if err != nil {
+			return err
+		}
+		files[path] = ff
+		v.printf("following %s", path)
+	}
+
+	for {
+		paths, _ = filepath.Glob(pattern)
+		for _, path := range paths {
+			if _, found := files[path]; found {
+				continue
+			}
+			ff, err := openFollowed(path, false)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to follow %s: %v\n", path, err)
+				continue
+			}
+			files[path] = ff
+			v.printf("following new file %s", path)
+		}
+
+		paths = paths[:0]
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			ff := files[path]
+
+			// read the rest of the file before checking for rotation
+			if p.followLines(ff) {
+				p.drain()
+				return nil
+			}
+
+			how, err := ff.rotated()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reopen %s: %v\n", path, err)
+				continue
+			}
+			if how != "" {
+				v.printf("%s was %s", path, how)
+				if p.followLines(ff) {
+					p.drain()
+					return nil
+				}
+			}
+		}
+
+		p.drain()
+		time.Sleep(followInterval)
+	}
+}
+
+// followLines processes the new lines of ff and reports whether to stop.
+func (p *processor) followLines(ff *followedFile) (stop bool) {
+	lines, err := ff.readLines()
+	if err != nil && !errors.Is(err, io.EOF) {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", ff.path, err)
+	}
+
+	for _, line := range lines {
+		process, stop := p.next()
+		if stop {
+			return true
+		}
+		if !process {
+			continue
+		}
+		if isBinary(line) {
+			line, process = p.binaryRecord(line)
+		}
+		if process {
+			p.processRecord(line)
+		}
+	}
+	return false
+}
+
+// hasMeta reports whether path contains any of the glob special characters.
+func hasMeta(path string) bool {
+	for _, c := range path {
+		switch c {
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
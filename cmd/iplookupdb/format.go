@@ -0,0 +1,257 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Formats of the output.
+const (
+	formatCSV     = "csv"     // a CSV record for each IP
+	formatJSON    = "json"    // a JSON array with an object for each IP
+	formatNDJSON  = "ndjson"  // a JSON object on its own line for each IP
+	formatMISP    = "misp"    // a MISP event with an attribute for each IP
+	formatSTIX    = "stix"    // a STIX 2.1 bundle with an observable for each IP
+	formatParquet = "parquet" // a Parquet file with a row for each IP
+)
+
+// record is an output record.
+type record struct {
+	columns []string // names of the output columns, in order
+	fields  env      // values of the columns and the other fields of the IP
+}
+
+// recordWriter writes output records in a format.
+type recordWriter interface {
+	// write writes the record r. Formats that stream records write and
+	// flush r immediately for interactive use.
+	write(r record) error
+
+	// flush writes any buffered records.
+	flush() error
+
+	// close completes the output, such as by writing the records held by
+	// formats that contain all records.
+	close() error
+}
+
+// outputColumns returns the names of the output columns, which are the
+// -fields, if given.
+func (p *processor) outputColumns() []string {
+	if p.cfg.fields != nil {
+		return p.cfg.fields
+	}
+	if p.cfg.summary != "" {
+		return append(slices.Clone(summaryColumns[p.cfg.summary]), "count")
+	}
+
+	var columns []string
+	if !p.cfg.csvIn {
+		columns = []string{"ip"} // -csv-in rows already have the IP
+	}
+	columns = append(columns, typeColumns[p.dbType()]...)
+	if len(p.cfg.fallbackNames) > 0 {
+		columns = append(columns, "source_db")
+	}
+	if p.cfg.portColumn {
+		columns = slices.Insert(columns, 1, "port")
+	}
+	if p.cfg.latency {
+		columns = append(columns, "latency_us")
+	}
+	for _, name := range p.extraColumns() {
+		// such as asn from both -asn-db and an ASN -db
+		if !slices.Contains(columns, name) {
+			columns = append(columns, name)
+		}
+	}
+	if p.cfg.dupes == dupesCollapse {
+		columns = append(columns, "count")
+	}
+	return columns
+}
+
+// newRecordWriter returns the writer for the output format.
+func (p *processor) newRecordWriter() recordWriter {
+	switch p.cfg.format {
+	case formatJSON:
+		return &jsonWriter{w: p.out, array: true}
+	case formatNDJSON:
+		return &jsonWriter{w: p.out}
+	case formatMISP:
+		return &collectWriter{writeAll: func(records []env) error {
+			return writeMISP(p.out, records, p.extraColumns(), p.outputDate())
+		}}
+	case formatSTIX:
+		return &collectWriter{writeAll: func(records []env) error {
+			return writeSTIX(p.out, records, p.outputDate())
+		}}
+	case formatParquet:
+		return newParquetWriter(p.out, p.columns)
+	}
+	if p.cfg.template != nil {
+		return &templateWriter{w: p.out, t: p.cfg.template, fields: p.cfg.templateFields}
+	}
+	cw := &csvWriter{w: p.w, decimal: p.cfg.decimal}
+	if p.cfg.header && !p.cfg.csvIn {
+		cw.header = p.columns
+	}
+	cw.batch = p.cfg.workers > 1
+	return cw
+}
+
+// outputDate returns the date of the output for formats that include one,
+// which is the build date of the db if the output is deterministic.
+func (p *processor) outputDate() time.Time {
+	if p.cfg.deterministic {
+		return time.Unix(int64(p.db.Metadata().BuildEpoch), 0).UTC()
+	}
+	return time.Now().UTC()
+}
+
+// csvFields returns the CSV fields of the columns of r. Empty locations are
+// unknown, and coordinates and distances use the decimal separator, if not
+// empty.
+func csvFields(r record, decimal string) []string {
+	fields := make([]string, len(r.columns))
+	for n, name := range r.columns {
+		fields[n] = formatValue(r.fields[name])
+		switch name {
+		case "ip", "city", "subdivision", "country":
+			if fields[n] == "" {
+				fields[n] = "unknown"
+			}
+		}
+		if decimal != "" && slices.Contains(localizedColumns, name) {
+			fields[n] = strings.Replace(fields[n], ".", decimal, 1)
+		}
+	}
+	return fields
+}
+
+// csvWriter writes each record as a CSV record. Empty locations are
+// written as unknown.
+type csvWriter struct {
+	w       *csv.Writer
+	header  []string // written before the first record, if not nil
+	batch   bool     // flush only when full or by flush, for throughput
+	decimal string   // decimal separator of coordinates and distances, if not .
+}
+
+// writeHeader writes the header, if any, once.
+func (cw *csvWriter) writeHeader() {
+	if cw.header != nil {
+		cw.w.Write(cw.header)
+		cw.header = nil
+	}
+}
+
+func (cw *csvWriter) write(r record) error {
+	cw.writeHeader()
+	cw.w.Write(csvFields(r, cw.decimal))
+	if cw.batch {
+		return nil
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvWriter) flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// close writes the header if there were no records.
+func (cw *csvWriter) close() error {
+	cw.writeHeader()
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonWriter writes each record as a JSON object with the output columns as
+// members, in order. Objects are written on separate lines, as NDJSON, or
+// as the elements of an array.
+type jsonWriter struct {
+	w     io.Writer
+	array bool
+	n     int // number of records written
+}
+
+func (jw *jsonWriter) write(r record) error {
+	var b bytes.Buffer
+	if jw.array {
+		if jw.n == 0 {
+			b.WriteString("[\n")
+		} else {
+			b.WriteString(",\n")
+		}
+	}
+
+	b.WriteByte('{')
+	for n, name := range r.columns {
+		if n > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(name)
+		v, err := json.Marshal(r.fields[name])
+		if err != nil {
+			return err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	if !jw.array {
+		b.WriteByte('\n')
+	}
+
+	jw.n++
+	_, err := jw.w.Write(b.Bytes())
+	return err
+}
+
+func (jw *jsonWriter) flush() error {
+	return nil
+}
+
+func (jw *jsonWriter) close() error {
+	if !jw.array {
+		return nil
+	}
+
+	end := "\n]\n"
+	if jw.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(jw.w, end)
+	return err
+}
+
+// collectWriter holds the records to be written by close in a format that
+// contains all records, such as a MISP event.
+type collectWriter struct {
+	records  []env
+	writeAll func(records []env) error
+}
+
+func (cw *collectWriter) write(r record) error {
+	cw.records = append(cw.records, r.fields)
+	return nil
+}
+
+func (cw *collectWriter) flush() error {
+	return nil
+}
+
+func (cw *collectWriter) close() error {
+	return cw.writeAll(cw.records)
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+)
+
+// inListExpr is true if the field of a record is one of the values, which
+// are compared without regard to case.
+type inListExpr struct {
+	field  string
+	values map[string]bool
+}
+
+func (l *inListExpr) eval(e env) (any, error) {
+	return l.values[normalizeListValue(l.field, formatValue(e[l.field]))], nil
+}
+
+// normalizeListValue returns v of the field as it is compared by
+// inListExpr, in upper case and, for asn, without an AS prefix.
+func normalizeListValue(field, v string) string {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	if field == "asn" {
+		v = strings.TrimPrefix(v, "AS")
+	}
+	return v
+}
+
+// newInList returns an inListExpr for the comma-separated values of the
+// field.
+func newInList(field, list string) *inListExpr {
+	l := &inListExpr{field: field, values: make(map[string]bool)}
+	for _, v := range strings.Split(list, ",") {
+		if v = normalizeListValue(field, v); v != "" {
+			l.values[v] = true
+		}
+	}
+	return l
+}
+
+// listFilter is a -include-* or -exclude-* flag.
+type listFilter struct {
+	field   string // compared to the values
+	list    string // comma-separated values
+	exclude bool   // exclude rather than include records with the values
+}
+
+// parseListFilters returns an expression that is true for records that
+// match every include filter and no exclude filter, or the inverse if
+// invert is true. It returns nil if there are no filters.
+func parseListFilters(filters []listFilter, invert bool) expr {
+	var x expr
+	for _, f := range filters {
+		if f.list == "" {
+			continue
+		}
+
+		var y expr = newInList(f.field, f.list)
+		if f.exclude {
+			y = &notExpr{y}
+		}
+		if x == nil {
+			x = y
+		} else {
+			x = &logicalExpr{x: x, y: y}
+		}
+	}
+
+	if x != nil && invert {
+		x = &notExpr{x}
+	}
+	return x
+}
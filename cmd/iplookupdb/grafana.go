@@ -0,0 +1,143 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// grafanaAllTarget is the Grafana target with the counts by all labels.
+const grafanaAllTarget = "records"
+
+// grafanaColumn is a column of a Grafana table response.
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// grafanaTable is a Grafana table response.
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+// grafanaSeries is a Grafana time series response with a single datapoint
+// of the current count. The target is the label values separated by /.
+type grafanaSeries struct {
+	Target     string  `json:"target"`
+	Datapoints [][]any `json:"datapoints"`
+}
+
+// aggregate returns the counts of records by the labels in by, which must
+// be a subset of the labels of m, with the highest count first.
+func (m *metrics) aggregate(by []string) (values [][]string, counts []int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index := make(map[string]int)
+	for key, count := range m.counts {
+		var group []string
+		for n, name := range m.labels {
+			if slices.Contains(by, name) {
+				group = append(group, m.values[key][n])
+			}
+		}
+		k := strings.Join(group, "\x00")
+		n, found := index[k]
+		if !found {
+			n = len(values)
+			index[k] = n
+			values = append(values, group)
+			counts = append(counts, 0)
+		}
+		counts[n] += count
+	}
+
+	order := make([]int, len(values))
+	for n := range order {
+		order[n] = n
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		if c := cmp.Compare(counts[b], counts[a]); c != 0 {
+			return c
+		}
+		return slices.Compare(values[a], values[b])
+	})
+
+	sortedValues := make([][]string, len(order))
+	sortedCounts := make([]int, len(order))
+	for n, i := range order {
+		sortedValues[n], sortedCounts[n] = values[i], counts[i]
+	}
+	return sortedValues, sortedCounts
+}
+
+// grafanaSearch lists the targets of the Grafana JSON datasource, which are
+// the counts by all labels and by each label.
+func (m *metrics) grafanaSearch(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(append([]string{grafanaAllTarget}, m.labels...))
+}
+
+// grafanaQuery returns the counts for each target of a Grafana JSON
+// datasource query, as a table or as time series with the current count.
+func (m *metrics) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var query struct {
+		Targets []struct {
+			Target string `json:"target"`
+			Type   string `json:"type"`
+		} `json:"targets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	result := []any{}
+	for _, t := range query.Targets {
+		by := m.labels
+		if t.Target != grafanaAllTarget && t.Target != "" {
+			if !slices.Contains(m.labels, t.Target) {
+				http.Error(w, "unknown target "+t.Target, http.StatusBadRequest)
+				return
+			}
+			by = []string{t.Target}
+		}
+		values, counts := m.aggregate(by)
+
+		if t.Type == "table" {
+			table := grafanaTable{Type: "table", Rows: [][]any{}}
+			for _, name := range by {
+				table.Columns = append(table.Columns, grafanaColumn{name, "string"})
+			}
+			table.Columns = append(table.Columns, grafanaColumn{"count", "number"})
+			for n, group := range values {
+				var row []any
+				for _, v := range group {
+					row = append(row, v)
+				}
+				table.Rows = append(table.Rows, append(row, counts[n]))
+			}
+			result = append(result, table)
+			continue
+		}
+
+		for n, group := range values {
+			result = append(result, grafanaSeries{
+				Target:     strings.Join(group, "/"),
+				Datapoints: [][]any{{counts[n], now}},
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,279 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The gRPC service is defined by proto/iplookupdb/v1/lookup.proto. Its few
+// messages are encoded by hand, with protowire, rather than by generated
+// code, so the field numbers here must match the .proto file.
+
+// grpcService is the full name of the gRPC service.
+const grpcService = "iplookupdb.v1.Lookup"
+
+// newGRPCServer returns a gRPC server for the lookup service of s.
+func newGRPCServer(s *server) *grpc.Server {
+	gs := grpc.NewServer(grpc.ForceServerCodec(grpcCodec{}))
+	gs.RegisterService(&grpc.ServiceDesc{
+		ServiceName: grpcService,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "LookupIP", Handler: grpcLookupIP},
+		},
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "LookupBatch",
+				Handler:       grpcLookupBatch,
+				ServerStreams: true,
+				ClientStreams: true,
+			},
+		},
+		Metadata: "proto/iplookupdb/v1/lookup.proto",
+	}, s)
+	return gs
+}
+
+// stopGRPC stops gs after the calls in progress finish or ctx is done,
+// whichever is first.
+func stopGRPC(ctx context.Context, gs *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		gs.Stop()
+	}
+}
+
+// grpcLookupIP handles the LookupIP method, which returns the record for an
+// IP.
+func grpcLookupIP(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	req := new(lookupIPRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+
+	s := srv.(*server)
+	handler := func(_ context.Context, req any) (any, error) {
+		return s.lookupRecord(req.(*lookupIPRequest).ip)
+	}
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + grpcService + "/LookupIP"}
+	return interceptor(ctx, req, info, handler)
+}
+
+// grpcLookupBatch handles the LookupBatch method, which streams a result for
+// each IP received until the client closes its side of the stream.
+func grpcLookupBatch(srv any, stream grpc.ServerStream) error {
+	s := srv.(*server)
+	for {
+		req := new(lookupIPRequest)
+		err := stream.RecvMsg(req)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		result := &lookupResult{ip: req.ip}
+		result.record, err = s.lookupRecord(req.ip)
+		if err != nil {
+			result.err = status.Convert(err).Message()
+		}
+		if err := stream.SendMsg(result); err != nil {
+			return err
+		}
+	}
+}
+
+// lookupRecord returns the record for ip, or a gRPC status error.
+func (s *server) lookupRecord(ip string) (*grpcRecord, error) {
+	records, p := s.records([]string{ip})
+	switch {
+	case p.invalid > 0:
+		return nil, status.Error(codes.InvalidArgument, "invalid IP")
+	case p.lookupErrors > 0:
+		return nil, status.Error(codes.Internal, "lookup failed")
+	case len(records) == 0:
+		return nil, status.Error(codes.NotFound, "no record")
+	}
+	return &grpcRecord{records[0]}, nil
+}
+
+// grpcCodec encodes the messages of the lookup service in the protobuf wire
+// format.
+type grpcCodec struct{}
+
+// grpcMarshaler is a message that can be sent.
+type grpcMarshaler interface {
+	marshal() []byte
+}
+
+// grpcUnmarshaler is a message that can be received.
+type grpcUnmarshaler interface {
+	unmarshal(b []byte) error
+}
+
+func (grpcCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(grpcMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (grpcCodec) Unmarshal(b []byte, v any) error {
+	m, ok := v.(grpcUnmarshaler)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal %T", v)
+	}
+	return m.unmarshal(b)
+}
+
+func (grpcCodec) Name() string {
+	return "proto"
+}
+
+// lookupIPRequest is the LookupIPRequest message.
+type lookupIPRequest struct {
+	ip string
+}
+
+func (r *lookupIPRequest) unmarshal(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			r.ip, b = v, b[n:]
+			continue
+		}
+
+		// skip unknown fields
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// grpcRecord is the Record message for the fields of a record.
+type grpcRecord struct {
+	e env
+}
+
+// grpcRecordStrings are the string fields of the Record message, by field
+// number.
+var grpcRecordStrings = []struct {
+	num  protowire.Number
+	name string
+}{
+	{1, "ip"}, {2, "city"}, {3, "subdivision"}, {4, "country"},
+	{5, "country_iso"}, {6, "continent"}, {7, "postal"}, {8, "timezone"},
+}
+
+// grpcRecordTyped are the fields of a record that have their own field in
+// the Record message rather than being in its fields map.
+var grpcRecordTyped = []string{
+	"ip", "city", "subdivision", "country", "country_iso", "continent",
+	"postal", "timezone", "latitude", "longitude", "accuracy_radius",
+	"is_private",
+}
+
+func (r *grpcRecord) marshal() []byte {
+	var b []byte
+	for _, f := range grpcRecordStrings {
+		if v := formatValue(r.e[f.name]); v != "" {
+			b = protowire.AppendTag(b, f.num, protowire.BytesType)
+			b = protowire.AppendString(b, v)
+		}
+	}
+
+	if lat, ok := r.e["latitude"].(float64); ok {
+		lon, _ := r.e["longitude"].(float64)
+		radius, _ := r.e["accuracy_radius"].(float64)
+		b = protowire.AppendTag(b, 9, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(lat))
+		b = protowire.AppendTag(b, 10, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(lon))
+		b = protowire.AppendTag(b, 11, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(radius))
+		b = protowire.AppendTag(b, 12, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if r.e["is_private"] == true {
+		b = protowire.AppendTag(b, 13, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+
+	// the other fields, such as asn or computed columns, are in the map,
+	// sorted so the encoding is deterministic
+	var names []string
+	for name, v := range r.e {
+		if !slices.Contains(grpcRecordTyped, name) && formatValue(v) != "" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, name)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, formatValue(r.e[name]))
+
+		b = protowire.AppendTag(b, 14, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+// lookupResult is the LookupResult message for one IP of a batch.
+type lookupResult struct {
+	ip     string
+	record *grpcRecord // nil if err is set
+	err    string
+}
+
+func (r *lookupResult) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, r.ip)
+	if r.record != nil {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, r.record.marshal())
+	}
+	if r.err != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, r.err)
+	}
+	return b
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hostingEnricher adds whether each IP is in the published ranges of a
+// hosting provider, such as a cloud or VPS provider, and which one.
+type hostingEnricher struct {
+	specs     []string // range lists, read by open
+	providers prefixTable
+}
+
+// newHostingEnricher returns an enricher using the range lists in specs,
+// each of the form provider=file or file, where the provider defaults to the
+// file name without its extension.
+func newHostingEnricher(specs []string) *hostingEnricher {
+	return &hostingEnricher{specs: specs}
+}
+
+// open reads the range lists.
+func (x *hostingEnricher) open() error {
+	if err := x.load(); err != nil {
+		return fmt.Errorf("-hosting-ranges: %w", err)
+	}
+	return nil
+}
+
+// load reads the range lists.
+func (x *hostingEnricher) load() error {
+	for _, spec := range x.specs {
+		provider, name, found := strings.Cut(spec, "=")
+		if !found {
+			name = spec
+			provider = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		}
+
+		prefixes, err := readRangeList(name)
+		if err != nil {
+			return err
+		}
+		if len(prefixes) == 0 {
+			return fmt.Errorf("%s: no ranges found", name)
+		}
+		for _, prefix := range prefixes {
+			x.providers.add(prefix, provider)
+		}
+	}
+	return nil
+}
+
+// readRangeList returns the prefixes in the range list name. Lists in JSON,
+// such as those published by AWS, Google Cloud, Azure, and Oracle Cloud,
+// contain the prefixes as string values anywhere in the document. Other
+// lists, such as plain text lists of CIDRs, geofeeds (RFC 8805) published by
+// providers like DigitalOcean and Linode, and prefix lists of a provider's
+// ASNs, such as for OVH or Hetzner, have a prefix or IP as the first field of
+// each line. Blank lines and lines starting with # are ignored.
+func readRangeList(name string) ([]netip.Prefix, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		var doc any
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		var prefixes []netip.Prefix
+		jsonPrefixes(doc, &prefixes)
+		return prefixes, nil
+	}
+
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		field, _, _ := strings.Cut(text, ",")
+		field = strings.Fields(field)[0]
+
+		prefix, err := parsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, scanner.Err()
+}
+
+// parsePrefix parses s as a prefix in CIDR notation or as a single IP.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	return netip.ParsePrefix(s)
+}
+
+// jsonPrefixes appends the string values in the JSON value v that are
+// prefixes in CIDR notation to prefixes.
+func jsonPrefixes(v any, prefixes *[]netip.Prefix) {
+	switch v := v.(type) {
+	case string:
+		if prefix, err := netip.ParsePrefix(v); err == nil {
+			*prefixes = append(*prefixes, prefix)
+		}
+	case []any:
+		for _, e := range v {
+			jsonPrefixes(e, prefixes)
+		}
+	case map[string]any:
+		for _, e := range v {
+			jsonPrefixes(e, prefixes)
+		}
+	}
+}
+
+func (x *hostingEnricher) addedColumns() []string {
+	return []string{"is_datacenter", "provider"}
+}
+
+// enrich adds the is_datacenter and provider fields to the record e.
+func (x *hostingEnricher) enrich(e env) error {
+	e["is_datacenter"], e["provider"] = false, ""
+
+	addr, err := netip.ParseAddr(formatValue(e["ip"]))
+	if err != nil {
+		return nil // changed by an earlier enricher
+	}
+	if _, provider, ok := x.providers.lookup(addr.Unmap()); ok {
+		e["is_datacenter"], e["provider"] = true, provider
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// outputAddr returns the form of addr used for output. IPv4-mapped IPv6
+// addresses, such as ::ffff:192.0.2.1, are output as IPv4 unless keepMapped
+// is true.
+func outputAddr(addr netip.Addr, keepMapped bool) netip.Addr {
+	if keepMapped {
+		return addr
+	}
+	return addr.Unmap()
+}
+
+// decorations are characters commonly found around IPs copied out of logs,
+// JSON, or prose, such as quotes, punctuation, and brackets.
+const decorations = "\"'`(){}<>,;."
+
+// cleanIP returns s with decorations removed, such as surrounding quotes and
+// brackets and trailing commas or semicolons. A key= prefix, as in
+// client="192.0.2.1", is also removed.
+//
+// Brackets around an IPv6 address with a port, as in [2001:db8::1]:8080,
+// are kept so the port can be stripped by lookup.ParseIP.
+func cleanIP(s string) string {
+	s = strings.TrimSpace(s)
+	if _, after, found := strings.Cut(s, "="); found {
+		s = after
+	}
+
+	for {
+		prev := s
+		s = strings.Trim(s, decorations)
+
+		open := strings.HasPrefix(s, "[")
+		closed := strings.HasSuffix(s, "]")
+		switch {
+		case open && closed:
+			s = s[1 : len(s)-1]
+		case open && !strings.Contains(s, "]"):
+			s = s[1:]
+		case closed && !strings.Contains(s, "["):
+			s = s[:len(s)-1]
+		}
+
+		if s == prev {
+			return s
+		}
+	}
+}
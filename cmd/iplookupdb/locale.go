@@ -0,0 +1,30 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// localizedColumns are the columns of the CSV output written with the decimal
+// separator of the -locale, which are the coordinates and distances.
+var localizedColumns = []string{"latitude", "longitude", "distance_km", "distance_mi"}
+
+// decimalSeparator returns the decimal separator of locale, a BCP 47 language
+// tag such as de or fr-CA, such as "," for de.
+func decimalSeparator(locale string) (string, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", err
+	}
+
+	// the separator is what is left of a number formatted in the locale
+	// without its digits, which may not be ASCII, such as in Arabic
+	s := message.NewPrinter(tag).Sprintf("%.1f", 1.5)
+	return strings.TrimFunc(s, unicode.IsDigit), nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDecimalSeparator(t *testing.T) {
+	tests := []struct {
+		locale  string
+		want    string
+		wantErr bool
+	}{
+		{locale: "en", want: "."},
+		{locale: "en-US", want: "."},
+		{locale: "de", want: ","},
+		{locale: "fr-CA", want: ","},
+		{locale: "pt-BR", want: ","},
+		{locale: "ja", want: "."},
+		{locale: "ar", want: "٫"},
+		{locale: "not a locale!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := decimalSeparator(tt.locale)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("decimalSeparator(%q) error = %v, want error %t", tt.locale, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("decimalSeparator(%q) = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestCSVFieldsDecimal(t *testing.T) {
+	r := record{
+		columns: []string{"ip", "latitude", "distance_mi", "latency_us"},
+		fields:  env{"ip": "81.2.69.142", "latitude": 51.5142, "distance_mi": nil, "latency_us": 1.5},
+	}
+
+	tests := []struct {
+		decimal string
+		want    []string
+	}{
+		{"", []string{"81.2.69.142", "51.5142", "", "1.5"}},
+		{",", []string{"81.2.69.142", "51,5142", "", "1.5"}},
+	}
+
+	for _, tt := range tests {
+		if got := csvFields(r, tt.decimal); !slices.Equal(got, tt.want) {
+			t.Errorf("csvFields with %q = %q, want %q", tt.decimal, got, tt.want)
+		}
+	}
+}
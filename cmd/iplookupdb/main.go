@@ -0,0 +1,1745 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+/*
+iplookupdb utilizes the MaxMind GeoIP and GeoLite databases to look up IP
+addresses. To use this tool, you must first create an account and download the
+necessary database from MaxMind.
+See https://dev.maxmind.com/geoip/geolite2-free-geolocation-data.
+
+Usage:
+
+  iplookupdb [flags] [ip address ...]
+  iplookupdb config check [flags]
+  iplookupdb connections [-db database] [-lang lang] [-refresh interval | -ss file]
+  iplookupdb dbinfo [flags] [database ...]
+  iplookupdb auth login [-account-id id] | logout | status
+  iplookupdb fail2ban [-db database] [-server addr] [-jail name] [-json] [-log file] ip
+  iplookupdb mrt [-db database] [-lang lang] file ...
+  iplookupdb selftest [-db database]
+  iplookupdb sets [-db database | -blocks file -locations file] [-format ipset|nftables] [-name name] country ...
+  iplookupdb sshreport [-db database] [-lang lang] [-by ip|country] [file ...]
+  iplookupdb traceroute [-db database] [-lang lang] [-format text|csv] [file]
+  iplookupdb update [-dir dir] [-editions list] [-account-id id -license-key-file file] [-config file]
+
+The flags are:
+
+  -alert-filter string
+    	Post records matching the expression to the -alert-webhook.
+  -alert-format string
+    	Format of the webhook payload: generic or slack, which also works for Teams.
+    	(default "generic")
+  -alert-webhook string
+    	URL of the webhook to post records matching -alert-filter to.
+  -annotate
+    	Output each input line with a summary of its first IP appended.
+  -annotate-format string
+    	Format of the summary appended by -annotate. (default " [{country_iso}/{subdivision}/{city}]")
+  -anon-db string
+    	Anonymous-IP database, such as GeoIP2-Anonymous-IP.mmdb, used to add is_anonymous,
+    	is_vpn, is_tor, is_hosting, and is_proxy.
+  -asn-db string
+    	ASN database, such as GeoLite2-ASN.mmdb, used to add the asn and as_org of each
+    	IP.
+  -cache-size int
+    	Maximum number of locations cached by -dupes cache, evicting the least
+    	recently used. 0 is no limit. Implies -dupes cache.
+  -checkpoint string
+    	File to periodically record progress to for -resume.
+  -checkpoint-every int
+    	Number of input records between checkpoints. (default 10000)
+  -clean
+    	Remove quotes, brackets, and trailing punctuation around inputs.
+  -compute value
+    	Add a column computed by an expression, e.g., 'risk=is_private ? 0 : 50'. May be repeated.
+  -config string
+    	Config file with default flag values. Defaults to iplookupdb/config.toml in
+    	the user config directory, if it exists.
+  -csv-in
+    	Read CSV rows and write each row with the output columns for its IP
+    	appended.
+  -custom-db string
+    	Custom MMDB, such as an internal db of office and VPN networks, used to add
+    	the -custom-fields of each IP.
+  -custom-fields string
+    	Comma-separated keys of the -custom-db records to add as columns, e.g.,
+    	site,vlan,owner. Nested keys are dotted paths, e.g., location.building.
+  -db string
+    	Path to the GeoLite2 City database, or a comma-separated list of databases
+    	to try in order, e.g., GeoIP2-City.mmdb,GeoLite2-City.mmdb. (default
+    	"GeoLite2-City.mmdb")
+  -dedupe string
+    	Output only the first occurrence of each IP, tracking the IPs output in memory or on
+    	disk, for inputs with too many unique IPs to fit in memory.
+  -delimiter string
+    	Delimiter for the CSV output. (default ",")
+  -deterministic
+    	Guarantee byte-identical output for identical input and database.
+  -dupes string
+    	Handling of duplicate IPs: lookup, cache, or collapse. (default "lookup")
+  -encoding string
+    	Encoding of the output: utf-8, utf-8-bom, or utf-16le. (default "utf-8")
+  -enrich value
+    	Run the registered enricher on each record. May be repeated.
+  -event-ip string
+    	Field of -events containing the IP. Use dots for nested fields, e.g.,
+    	source.ip. (default "ip")
+  -event-target string
+    	Field added to -events with the location. (default "geo")
+  -events
+    	Read NDJSON events and write each event with the location of its IP added.
+  -exclude-asn string
+    	Comma-separated ASNs not to output. Needs the asn field.
+  -exclude-continent string
+    	Comma-separated continent codes not to output.
+  -exclude-country string
+    	Comma-separated ISO country codes not to output, e.g., CN,RU.
+  -exec-columns string
+    	Comma-separated fields added by -exec-enrich to output as columns.
+  -exec-concurrency int
+    	Maximum number of records to enrich at once. (default 4)
+  -exec-enrich string
+    	External command that receives each record as JSON and returns JSON fields
+    	to merge.
+  -extract
+    	Look up every IP found in each input line, such as a log line, instead of one IP
+    	per line.
+  -fields string
+    	Comma-separated fields to output, in order, e.g., ip,country_iso,latitude,longitude.
+  -filter string
+    	Only output records matching the expression, e.g., 'country_iso=="RU"'.
+  -follow
+    	Keep reading the -in file, which may be a glob, as lines are added, like
+    	tail -F.
+  -format string
+    	Format of the output: csv, json, ndjson, misp, stix, or parquet. (default "csv")
+  -from string
+    	Reference point as latitude,longitude used to add the distance_km of each
+    	IP, e.g., 51.5,-0.13.
+  -from-ip string
+    	IP whose location is the reference point used to add the distance_km of
+    	each IP.
+  -grpc string
+    	Address to serve lookups over gRPC on, e.g., :9090.
+  -header
+    	Write a header row with the names of the columns.
+  -hosting-ranges value
+    	Hosting provider range list, as provider=file or file, used to add
+    	is_datacenter and provider. May be repeated.
+  -in string
+    	Input file path. If not specified, reads from standard input.
+  -include-asn string
+    	Comma-separated ASNs to output, e.g., 13335,AS15169. Needs the asn field.
+  -include-continent string
+    	Comma-separated continent codes to output, e.g., EU,NA.
+  -include-country string
+    	Comma-separated ISO country codes to output, e.g., US,CA.
+  -invert
+    	Output the records that do not match the -include and -exclude flags
+    	instead.
+  -ip-column int
+    	Column of -csv-in rows containing the IP, starting at 1. (default 1)
+  -keep-mapped
+    	Output IPv4-mapped IPv6 addresses in IPv6 form.
+  -lang string
+    	Language for GeoIP lookup results, or a comma-separated list to fall back
+    	through, e.g., ja,en. (default "en")
+  -latency
+    	Output the lookup duration of each record in microseconds.
+  -list-langs
+    	List the languages of the database and exit.
+  -locale string
+    	Locale, such as de or fr-CA, whose decimal separator is used for the coordinates
+    	and distances of the CSV output.
+  -max int
+    	Maximum number of input records to process. 0 processes all.
+  -max-age duration
+    	Maximum age of the database, e.g., 720h. 0 allows any age.
+  -max-expand int
+    	Maximum number of addresses a CIDR or range input is expanded to. (default 65536)
+  -metrics-addr string
+    	Address to serve Prometheus metrics of the output records on, e.g., :9100.
+  -metrics-labels string
+    	Comma-separated fields used as labels of the metrics. (default "country_iso")
+  -out string
+    	Output file path. If not specified, writes to standard output.
+  -pfx2as string
+    	CAIDA pfx2as file or ASN MMDB used to add the announced prefix and origin AS
+    	of each IP.
+  -port-column
+    	Output the port of host:port inputs as a column after the IP.
+  -private string
+    	Handling of private and other special IPs, such as loopback: label, skip, or
+    	internal. (default "label")
+  -private-db string
+    	Internal sites MMDB or CSV used with -private internal.
+  -private-label string
+    	Label used for private and other special IPs with -private label. Defaults to
+    	the scope of the IP, e.g., private or loopback.
+  -progress
+    	Write progress, such as records/sec and the ETA, to stderr periodically and a summary
+    	at exit.
+  -progress-interval duration
+    	Interval between -progress reports. (default 10s)
+  -rdns
+    	Add the hostname of each IP from a reverse DNS (PTR) lookup.
+  -rdns-concurrency int
+    	Maximum number of -rdns lookups at once. (default 16)
+  -rdns-timeout duration
+    	Maximum time to wait for each -rdns lookup. (default 2s)
+  -reload-interval duration
+    	Interval between checks for a new -db file in -serve, -grpc, -watch-dir,
+    	-follow, and -reopen modes. 0 only reloads on SIGHUP. (default 1m0s)
+  -reopen
+    	Reopen the -in named pipe when its writers close it, instead of stopping.
+  -resume
+    	Continue from the -checkpoint file, appending to the output.
+  -script string
+    	Starlark script with a process(record) function to modify, enrich, or drop
+    	records.
+  -serve string
+    	Address to serve lookups over HTTP on, e.g., :8080.
+  -skip int
+    	Number of input records to skip before processing.
+  -skip-invalid
+    	Silently skip inputs that are not valid IPs.
+  -skip-private
+    	Omit private and other special IPs from the output. Same as -private skip.
+  -stats
+    	Write run statistics as JSON to stderr at exit.
+  -stats-out string
+    	File to write run statistics as JSON to at exit.
+  -strict
+    	Stop at the first invalid input or failed lookup.
+  -summarize-ranges
+    	Output one record for consecutive addresses of a CIDR or range input with the
+    	same location.
+  -summary string
+    	Output the number of records for each country, city, or asn, most common
+    	first, instead of each record.
+  -template string
+    	Go template used to write each record as a line, e.g., '{{.IP}} is in
+    	{{.City}}, {{.Country}}'.
+  -type string
+    	Type of the database: auto, city, country, asn, anonymous-ip, isp, or
+    	domain. (default "auto")
+  -unique
+    	Output only the first occurrence of each IP. Same as -dedupe memory.
+  -units string
+    	Units of the distance from -from or -from-ip: km, added as distance_km, or mi,
+    	added as distance_mi. (default "km")
+  -validate
+    	Check the database, inputs, and output without any lookups.
+  -verbose
+    	Write diagnostics, such as database metadata and timing, to stderr.
+  -watch-dir string
+    	Directory to watch for new input files to process.
+  -workers int
+    	Number of IPs to look up at once. Output stays in input order. (default 1)
+
+You can specify IP addresses directly via the command line. Use the -in flag
+to read from a file. If no IP addresses are provided on the command line and
+the -in flag is not used, the program reads from stdin.
+
+The output is a comma-separated list of the IP address, city, subdivision
+(e.g., state for US-based addresses), and country. To change the separator,
+use the -delimiter flag. By default, the output is sent to stdout unless
+the -out flag is specified.
+
+The -lang flag must be one of the languages listed in the database metadata,
+which -list-langs prints. If it is not, the program exits with an error
+listing the valid options. It may also be a comma-separated list of
+languages, such as ja,en, to fall back through in order, so a name missing
+in the first language is taken from the next rather than being unknown.
+
+Private IP addresses and those of other special ranges, which are not in the
+GeoIP databases, are labeled with their scope by default: private for RFC
+1918 and unique local addresses, loopback, link-local, cgnat for the shared
+address space 100.64.0.0/10, multicast, documentation for the example ranges
+such as 192.0.2.0/24 and 2001:db8::/32, and unspecified. The label is output
+as the city, subdivision, country, and country_iso, so -summary country
+counts them by scope, -annotate shows it, and -include-country and
+-exclude-country select them by it, such as -exclude-country
+private,loopback. Use -private-label to label them all with the same text
+instead, -private skip to omit them from the output, or -private internal
+with -private-db to look them up in an internal sites database mapping
+private ranges to office locations. The internal sites database is either a
+MMDB using the GeoIP2 City layout or a CSV file with
+network,city,subdivision,country records, such as:
+
+  10.1.0.0/16,Dallas,Texas,United States
+
+The scope field is the scope of each IP, or public for all others, and may
+be output with -fields, such as -fields ip,scope,country, or used in -filter
+and -compute expressions, such as 'scope == "cgnat"'. The is_private field
+is true only for private addresses.
+
+To produce clean output from messy input, use -skip-invalid to silently skip
+inputs that are not valid IPs and -skip-private to omit private and other
+special IPs.
+
+IPv4-mapped IPv6 addresses, such as ::ffff:192.0.2.1, are looked up and output
+as IPv4. Use -keep-mapped to output them in IPv6 form instead. IPv6 zones,
+such as eth0 in fe80::1%eth0, only identify a local interface and are
+stripped.
+
+Inputs may include a port, such as 203.0.113.5:443 or [2001:db8::1]:8080. The
+port is stripped before the lookup. Use -port-column to output the port as a
+separate column after the IP address.
+
+Use -clean to accept IPs copied out of logs or JSON. It removes surrounding
+quotes and brackets, trailing commas and semicolons, and key= prefixes, so
+inputs such as "192.0.2.1", or [192.0.2.1]; are looked up instead of rejected.
+
+IPv4 addresses may also be given as a decimal or hexadecimal integer, such
+as 3221225985 or 0xC0000201, which are looked up and output as 192.0.2.1.
+The integer must be at least 16777216 (0x1000000), which is 1.0.0.0, so
+small numbers, such as a port, are rejected as invalid rather than looked up
+as an address in 0.0.0.0/8.
+
+By default, every occurrence of an IP is looked up. Use -dupes cache to serve
+repeated IPs from an in-memory cache, or -dupes collapse to output one row per
+IP, in the order first seen, with a count column appended. With collapse, the
+output is written after all input is read. Use -cache-size to bound the cache,
+which then evicts the least recently used IPs, for inputs with many distinct
+IPs. To output only the first occurrence of each IP as it is read, without
+a count, use -unique, which keeps the IPs output in memory. For inputs with
+too many unique IPs to fit in memory, such as hundreds of millions of lines,
+use -dedupe disk instead, which keeps them in a hash table in a temporary
+file in $TMPDIR, or the OS default, that is removed at exit. Each unique IP
+is still looked up and output once, at the cost of disk reads and writes.
+
+On Windows, the console output code page is set to UTF-8 while the program
+runs so localized names render correctly. For output consumed by Windows
+tools, use -encoding utf-8-bom to add a byte order mark or -encoding utf-16le
+to write UTF-16LE with a byte order mark.
+
+Use -skip N to skip the first N input records and -max N to process at most N
+records, such as to sample the head of a large file or to resume roughly where
+a previous run stopped. Records are counted whether or not they are valid IPs.
+
+Use -max-age to fail if the database was built longer ago than the given
+duration. Use -validate as a pre-flight check in scheduled jobs: it checks that
+the databases open and are fresh, the inputs are valid IPs, and the output can
+be created, and reports any problems on stderr without performing lookups or
+writing output. The exit status is non-zero if any problems are found.
+
+Use -verbose to write diagnostics to stderr, including the database metadata,
+the effective value of every flag, the time taken by each phase, and cache
+statistics when using -dupes cache.
+
+Use -latency to add a column with the duration of each lookup in microseconds,
+such as to observe tail latencies.
+
+For pipeline monitoring, use -stats to write a JSON summary of the run to
+stderr at exit, or -stats-out to write it to a file. The summary includes the
+number of records read and written, invalid IPs, lookup errors, unique IPs,
+the cache hit rate, the elapsed time, and records per second.
+
+On Unix systems, send SIGUSR1 to a running process, such as with "kill -USR1
+pid", to write the current progress and rate to stderr without interrupting
+processing. The progress is written before the next record is processed. Use
+-progress to write it every -progress-interval, 10 seconds by default, and
+once more at the end of the run, for batch jobs. When the input is a file,
+rather than a pipe, the progress includes the percentage of the input read and
+the estimated time remaining.
+
+For long runs, use -checkpoint to periodically record progress to a file,
+every -checkpoint-every input records and at the end of the input. If the
+run is interrupted, run the same command with -resume added to continue
+after the last checkpoint, appending to the existing output. When the input
+is a file, the run resumes by seeking to the recorded offset; otherwise, the
+records already processed are read and skipped. A few records processed
+after the last checkpoint may be written again. Since -resume appends, it
+cannot be used with the json, misp, stix, and parquet formats, which write a
+single document.
+
+Use -deterministic to guarantee byte-identical output for identical input and
+database, so output diffs can be used to detect data changes. Rows are written
+in input order, or first-seen order with -dupes collapse, and no timestamps or
+timings are written. Options that cannot provide this guarantee, such as
+-latency and -resume, are rejected.
+
+Use "iplookupdb config check" with the same flags as a lookup to resolve and
+print the effective settings, showing whether each came from a flag or the
+default. Conflicting or unknown flags are reported and the exit status is
+non-zero.
+
+Use "iplookupdb dbinfo" to print the metadata of the -db databases, a
+comma-separated list, and of the -asn-db, -anon-db, and -custom-db
+databases, or of the databases given as arguments: the database type, build
+epoch and date, age, node count, IP version, record size, and languages. The
+exit status is 2 if any database cannot be opened or, with -max-age, was
+built longer ago than the given duration, so a monitoring check can alert
+when a database is no longer being updated. It takes the same flags as a
+lookup, so the databases default to those set by the IPLOOKUPDB_ variables
+and the config file.
+
+  iplookupdb dbinfo -max-age 720h GeoLite2-City.mmdb GeoLite2-ASN.mmdb
+
+Use "iplookupdb selftest" for post-install validation. It looks up well-known
+public IPs and the addresses documented in the MaxMind test databases, prints
+PASS or FAIL for each based on the expected country, checks that a private IP
+is labeled with its scope, and exits non-zero if any check fails.
+
+Input that is not text, such as a gzip file or pcap capture, is rejected with
+a single diagnostic instead of a parse error for every line. Records within
+otherwise text input that contain NUL bytes or invalid UTF-8 are skipped, or
+sanitized and processed if -clean is used, and reported once along with a
+count at the end.
+
+Use "iplookupdb auth login" to store a MaxMind account ID and license key in
+the OS keyring, such as the macOS Keychain, Windows Credential Manager, or the
+Secret Service on Linux, rather than in plaintext flags or environment
+variables. The license key is read from stdin without echo. Use
+"iplookupdb auth status" to show the stored account and "iplookupdb auth
+logout" to remove the credentials.
+
+Use -annotate to geo-tag log files, such as auth.log or nginx access logs.
+Instead of CSV, each input line is output with a summary of the location of
+the first IP found in the line appended, preserving the structure of the log.
+Lines without an IP are output unchanged. The summary is set with
+-annotate-format, where {ip}, {city}, {subdivision}, {country}, and
+{country_iso} are replaced with their values. For example:
+
+  iplookupdb -annotate -in /var/log/auth.log
+
+The -fields flag selects the columns to output, in order, from the record
+fields, such as ip,country_iso,latitude,longitude,postal,timezone,continent,
+accuracy_radius, and any fields added by -compute, -script, or an enricher,
+latency_us with -latency, and count with -dupes collapse. By default, the
+columns are ip, city, subdivision, country, followed by any extra columns.
+The latitude, longitude, and accuracy_radius fields are empty if the
+database has no coordinates for an IP. With -header, a header row with the
+names of the columns is written first, even if there are no records.
+
+Use -filter to only output records matching an expression, such as:
+
+  iplookupdb -filter 'country_iso=="RU" or is_private' -in ips.txt
+
+Expressions compare the record fields ip, port, city, subdivision, country,
+country_iso, is_private, scope, continent, continent_code, postal, timezone,
+latitude, longitude, and accuracy_radius with string, number, or bool
+literals using ==, !=, <, <=, >, >=, and =~ (regular expression match),
+combined with and (&&), or (||), not (!), and parentheses. A field by itself
+is true if it is a true bool, a non-empty string, or a non-zero number.
+Values that cannot be compared, such as a missing port and a number, are not
+equal.
+
+Use -compute name=expression to add an output column computed from the record
+fields, such as:
+
+  iplookupdb -compute 'risk = is_private ? 0 : country_iso == "CN" ? 100 : 10'
+
+Computed expressions also support the conditional operator (?:), arithmetic
+with +, -, *, /, and %, and string concatenation with +. Computed columns are
+output after the other columns in the order given, and may be used by later
+-compute and -filter expressions.
+
+The -script flag runs a Starlark script on each record, after the lookup and
+before -compute and -filter. The script must define a function process(record)
+that is called with the record as a dict of its fields. The function may
+change fields or add new ones, and returns the record to output it or None to
+drop it. A global list named columns gives the names of fields added by the
+script that are output as extra columns, after any computed columns, and that
+may be used in -compute and -filter expressions. For example:
+
+  columns = ["site"]
+
+  def process(record):
+      if record["ip"].startswith("10.1."):
+          record["site"] = "dallas"
+      return record
+
+The -exec-enrich flag runs an external command with the shell for each
+record, which allows enrichment plugins to be written in any language. The
+record is written to the command's stdin as a JSON object, and the command
+writes a JSON object to stdout whose fields are merged into the record before
+any -script. Use -exec-columns to output fields added by the command as
+columns, after any computed columns; these fields may also be used in -compute
+and -filter expressions. Up to -exec-concurrency records are enriched at
+once, and records are still output in input order. If the command fails, the error is
+reported and the record is output without enrichment.
+
+The -enrich flag runs an enricher registered by name on each record, before
+any -exec-enrich command, and may be repeated to chain enrichers in order.
+Custom enrichers, such as lookups in an internal asset database or CMDB, are
+added by building iplookupdb with an extra file that implements the
+lookup.Enricher interface and calls lookup.RegisterEnricher from an init
+function. Enrichers run concurrently, limited by -exec-concurrency, and must
+be safe for concurrent use.
+
+The -watch-dir flag turns iplookupdb into a drop-folder service that checks
+the directory every few seconds and processes each new file dropped into it.
+A file is processed once its size stops changing, and hidden files, such as
+temporary files, are ignored. The output for a file is written to the done
+subdirectory as the file name with .csv appended, and the file is then moved
+to done, or to the failed subdirectory if it cannot be processed, such as a
+compressed file. If done or failed already has a file of the same name, a
+number is appended to the name, such as ips.txt.1 and ips.txt.1.csv, so
+earlier files are not overwritten. A file that cannot be moved is skipped
+until it is removed, rather than processed again. Use -verbose to log each
+processed file.
+
+The -follow flag keeps reading the -in file as lines are added, like tail -F,
+until interrupted or -max records are processed. The -in file may be a glob,
+such as '/var/log/nginx/*.log', to follow multiple files, and is checked for
+new files, which are read from the start. Files that exist when following
+starts are read from their end. Rotated files are followed whether logrotate
+renames and recreates them or uses copytruncate, and lines written to the old
+file before it was rotated are not lost. Use a glob that does not match the
+names of rotated files, such as access.log.1, to avoid reading them again.
+Combined with -extract or -annotate, live logs are enriched as they are
+written, without an external pipeline:
+
+  iplookupdb -follow -extract -in /var/log/nginx/access.log
+
+The -in file may be a named pipe (FIFO). By default, processing stops when all
+writers close the pipe. With -reopen or -follow, the pipe is reopened to wait
+for the next writer instead, until interrupted or -max records are processed.
+The prompt for IPs is only shown when stdin is a terminal, so it does not
+appear in the output when stdin is a pipe or file.
+
+The -events flag reads newline-delimited JSON (NDJSON) events and writes each
+event as JSON with the location of its IP added as an object in the
+-event-target field, so iplookupdb can be used as an exec processor in Vector,
+Fluent Bit, or Benthos pipelines. The IP is read from the -event-ip field,
+which may use dots to select a nested field, such as source.ip. All other
+fields of the event are passed through unchanged. Events without an IP, or
+that are not valid JSON, are written unchanged, and events whose record does
+not match -filter are dropped. For example:
+
+  echo '{"msg":"login","source":{"ip":"81.2.69.142"}}' |
+      iplookupdb -events -event-ip source.ip
+
+The sshreport subcommand reads sshd auth.log files, or stdin if none are
+given, and writes a CSV report of the IPs with failed logins from "Failed
+password" and "Invalid user" lines. Each row has the IP, its location, the
+number of attempts, and how many were for users that do not exist, with the
+most attempts first. Use -by country to summarize the attempts by country
+instead, with the number of attacking IPs from each.
+
+The fail2ban subcommand is designed to be invoked from a fail2ban action with
+the banned IP, such as actionban = iplookupdb fail2ban -jail <name> -log
+/var/log/iplookupdb-bans.log <ip>. It writes a single-line summary of the
+location of the IP, or JSON with -json, to stdout, and appends the summary
+with the time to the -log file, if given, to keep a ban log with geo context.
+
+Use -server with the address of a running iplookupdb -serve, such as
+localhost:8080, to look up the IP there rather than open the database for
+each ban. The instance must output the city, subdivision, country, and
+country_iso fields, such as with -fields
+ip,city,subdivision,country,country_iso. If it cannot be reached within 2
+seconds or does not answer, the IP is looked up in the -db database instead.
+
+The -format flag selects the format of the output. The default, csv, writes a
+CSV record for each IP. With misp, a single MISP event is written as JSON at
+the end, with an ip-src attribute for each IP, which can be pushed to MISP
+using its REST API or imported as an event. Each attribute has the location
+as a comment and as tags, such as geo:country_iso="GB", and any extra columns,
+such as from -compute, as tags of the form iplookupdb:name="value". The event
+is dated today, or with -deterministic, the date the database was built.
+
+With -format stix, a STIX 2.1 bundle is written as JSON at the end for
+exchange with threat intelligence platforms. The bundle has an ipv4-addr or
+ipv6-addr observable for each IP, a location object for each location, and a
+located-at relationship from each IP to its location. Private IPs and IPs
+without a known country have no location. Identifiers are derived from the
+content of each object, so the same IP or location always has the same
+identifier. Objects are created now, or with -deterministic, when the
+database was built.
+
+With -format parquet, the records are written to the -out file as a Parquet
+file, compressed with Snappy, for bulk enrichment jobs that feed Spark,
+DuckDB, and similar tools, without a lossy conversion from CSV. Each output
+column is an optional Parquet column: latitude, longitude, distance_km,
+distance_mi, and latency_us are doubles, accuracy_radius and count are
+64-bit integers, the is_ columns, such as is_private, are booleans, and the
+others, including computed columns, are strings. Unknown values, such as
+empty names, are null rather than unknown. The columns are in name order, as
+Parquet requires. For example:
+
+  iplookupdb -format parquet -out ips.parquet -in ips.txt
+
+With -format json or ndjson, each IP is written as a JSON object with the
+output columns as members, in order: ip, port with -port-column, city,
+subdivision, country, latency_us with -latency, any extra columns, such as
+from -compute, -enrich, or -script, and count with -dupes collapse. Empty
+locations are written as empty strings rather than unknown, and values keep
+their types, such as true or 1.5. With json, the objects are written as an
+array, which is completed at the end of the input. With ndjson, each object
+is written on its own line as soon as it is looked up, which suits large
+inputs, streaming, and -follow.
+
+The connections subcommand shows where the remote peers of the established
+TCP connections of the local system are located, as CSV with the number of
+connections to each peer, most first. Connections are read from
+/proc/net/tcp and /proc/net/tcp6 on Linux, or otherwise from the output of
+ss -tn. Use -refresh to clear the screen and show them again periodically,
+for a quick view of who the system is talking to, or -ss to read saved
+ss -tn output from a file, or - for stdin. Loopback peers are omitted.
+
+The -metrics-addr flag serves Prometheus metrics at /metrics on the given
+address while running, which is most useful with -follow or -watch-dir to
+chart the geography of a live log, such as attack sources, in Grafana without
+a separate exporter. The iplookupdb_records_total counter counts the output
+records by the fields in -metrics-labels, which may include computed columns
+or fields added by enrichers or the script, such as a blocklist match.
+
+The -metrics-addr server also implements the Grafana simple JSON datasource
+contract, which the Infinity datasource can also use, so Grafana panels can
+query the counts directly. POST /search lists the targets, which are records,
+for the counts by all -metrics-labels, and each label, for the counts by that
+label alone. POST /query returns each target as a table, with a column for
+each label and the count, or as time series with the current count.
+
+The sets subcommand writes firewall set definitions of all prefixes
+geolocated to the given countries, by ISO code, such as iplookupdb sets
+CN,RU. The prefixes are read from the -db database, or from the GeoLite2
+Country or City CSV files given by -blocks, which may be repeated for the
+IPv4 and IPv6 files, and -locations. With -format nftables, the default, a
+table with the sets name_v4 and name_v6 is written for nft -f. With -format
+ipset, the commands to create and fill the sets name-v4 and name-v6 are
+written for ipset restore. The name is set by -name and defaults to geo.
+
+The mrt subcommand reads MRT RIB dumps in the TABLE_DUMP_V2 format, such as
+those published by RouteViews and RIPE RIS, for routing-table-wide geographic
+analyses. Files ending in .gz or .bz2 are decompressed. A CSV row is written
+for each announced prefix with its origin AS, taken from the AS path of the
+first RIB entry, and the location of the first address of the prefix as a
+representative address.
+
+The traceroute subcommand reads the output of traceroute or mtr from a file
+or stdin and locates the IPs of each hop. By default, each line is written
+with the location of its IPs appended. With -format csv, a CSV record is
+written for each IP of each hop with the hop number and location instead.
+
+The -asn-db flag opens an ASN database, such as GeoLite2-ASN, alongside the
+City database and adds the autonomous system number and organization of each
+IP as the asn and as_org columns, after any computed columns and before the
+-pfx2as columns. The columns are empty for IPs that are not in the ASN
+database, and may be used in -compute and -filter expressions, such as
+'asn=="15169"'.
+
+The -anon-db flag opens an Anonymous-IP database, such as
+GeoIP2-Anonymous-IP, alongside the City database and adds whether each IP is
+an anonymizer as the is_anonymous, is_vpn, is_tor, is_hosting, and is_proxy
+columns, after the -asn-db columns, for fraud and abuse workflows. is_proxy
+is true for public and residential proxies. The columns are false for IPs
+that are not in the Anonymous-IP database, and may be used in -compute and
+-filter expressions. For example:
+
+  iplookupdb -anon-db GeoIP2-Anonymous-IP.mmdb -filter 'is_vpn or is_tor' -in ips.txt
+
+The -custom-db flag opens an MMDB of any layout, such as an internal
+database of office and VPN networks built with the MaxMind writer libraries,
+and adds the -custom-fields keys of the record for each IP as columns, which
+are empty if the IP or key is not in the database. A key of a nested map is
+a dotted path, such as location.building, output as the column
+location_building. Strings, numbers, and booleans are output as is, and maps
+and arrays as JSON. The columns must not already be fields, and are
+available to -filter, -compute, and -script.
+
+  iplookupdb -custom-db offices.mmdb -custom-fields site,vlan,owner -in ips.txt
+
+The -pfx2as flag adds the covering announced prefix and its origin AS to each
+record as the prefix and origin_as columns, after any computed columns, for
+routing and peering investigations. The file is a CAIDA prefix-to-AS file,
+optionally compressed with gzip or bzip2, or an ASN database, such as
+GeoLite2-ASN, if its name ends in .mmdb. Multiple origins are written as in
+the pfx2as file, such as 2497_2914. The columns are empty for IPs that are
+not in an announced prefix, and may be used in -compute and -filter
+expressions.
+
+The -alert-filter and -alert-webhook flags post each output record matching
+the expression to a webhook, such as for a denied country or a blocklist hit
+added by an enricher, which is most useful with -follow or -watch-dir. With
+-alert-format generic, the default, the payload is the record as a JSON
+object. With -alert-format slack, the payload is a message for a Slack or
+Microsoft Teams incoming webhook. Alerts are sent in the background, and
+errors are reported without stopping processing.
+
+The -hosting-ranges flag loads a range list published by a hosting provider,
+such as a cloud or VPS provider, and adds the is_datacenter and provider
+columns, after any computed columns, since whether an IP is residential or
+hosting is often the key question when the commercial Anonymous IP database
+is not available. The flag may be repeated for each provider, as
+provider=file, or as file to name the provider after the file. JSON lists,
+such as the AWS ip-ranges.json, Google Cloud cloud.json, Azure service tags,
+and Oracle Cloud public_ip_ranges.json, are read by finding the prefixes
+anywhere in the document. Other lists, such as plain text lists of CIDRs,
+geofeeds published by providers like DigitalOcean and Linode, and prefix
+lists of the ASNs of providers like OVH and Hetzner, are read from the first
+field of each line. If an IP is in the ranges of more than one provider, the
+most specific range is used, or the first list given for the same range.
+
+The -serve flag runs a long-running HTTP server on the address instead of
+reading input, so other tools can look up IPs without a MaxMind library. GET
+/lookup/{ip} responds with the record for the IP as a JSON object, and POST
+/lookup with a JSON array of up to 10000 IPs as the body responds with a JSON
+array of the records for the valid IPs. The records have the same columns as
+-format json, selected by -fields, and -filter, -compute, -script, and the
+enrichers apply. A single IP responds with 400 if it is invalid and 404 if it
+is skipped or filtered out. The database is opened once and shared by all
+requests. On an interrupt or SIGTERM, the server stops accepting requests and
+waits up to 10 seconds for requests in progress to finish. GET /metrics
+responds with the requests, IPs, records, invalid IPs, and failed lookups
+served so far, along with the -metrics-addr counts if enabled, in the
+Prometheus text format. For example:
+
+  iplookupdb -serve :8080 -fields ip,country_iso,latitude,longitude
+  curl localhost:8080/lookup/81.2.69.142
+  curl -d '["81.2.69.142","2001:218::1"]' localhost:8080/lookup
+
+The -grpc flag serves the same lookups over gRPC on the address, alone or
+alongside -serve, for services that prefer typed RPCs to JSON over HTTP. The
+Lookup service, defined in proto/iplookupdb/v1/lookup.proto, has LookupIP,
+which returns the Record for an IP, and LookupBatch, which streams a
+LookupResult back for each IP streamed to it as each is looked up. A Record
+has the main location fields, with the other fields of the record, such as
+asn or computed columns, in its fields map. LookupIP fails with
+INVALID_ARGUMENT if the IP is invalid and NOT_FOUND if it is skipped or
+filtered out, while LookupBatch sets the error of the result instead. Generate
+a client from the .proto file with protoc or buf. For example:
+
+  iplookupdb -grpc :9090 -asn-db GeoLite2-ASN.mmdb
+  grpcurl -plaintext -proto proto/iplookupdb/v1/lookup.proto \
+    -d '{"ip":"81.2.69.142"}' localhost:9090 iplookupdb.v1.Lookup/LookupIP
+
+The command is in cmd/iplookupdb and is installed with go install
+github.com/bnixon67/iplookupdb/cmd/iplookupdb@latest. The lookup package,
+github.com/bnixon67/iplookupdb/lookup, provides the lookups to other Go
+programs. Its Looker type opens a City database with Open, looks up an IP with
+Lookup or LookupString, and looks up the IP on each line of a reader with
+Stream. Lookup takes a netip.Addr, LookupNetIP takes a net.IP, and both look
+up IPv4-mapped IPv6 addresses as IPv4 and strip zones, as does Normalize. IPs
+are parsed by ParseIP, which accepts the same notations as the command, such
+as host:port and integers.
+
+The cmd/liblookup package builds the lookup package as a C shared library
+with go build -buildmode=c-shared -o liblookup.so ./cmd/liblookup, so
+Python, Ruby, or PHP programs can look up IPs without running iplookupdb as
+a service. It exports lookup_open to open a database, lookup_json to return
+the location of an IP as a JSON object with the same fields as -format json,
+lookup_free to free the returned strings, lookup_set_lang, and lookup_close.
+
+The cmd/lookupwasm package builds the lookup package as a WebAssembly module
+with GOOS=js GOARCH=wasm go build -o lookup.wasm ./cmd/lookupwasm, so
+browser tools and edge workers can look up IPs with a City database supplied
+by the host as a Uint8Array. It sets a global iplookupdb object with open,
+setLang, lookup, and close methods, and lookup returns the same JSON as
+lookup_json. Go programs compiled for WASI, with GOOS=wasip1, can use the
+lookup package directly, opening a database supplied by the host with
+FromBytes.
+
+The -workers flag looks up and enriches up to that many IPs at once, which
+speeds up large inputs on machines with several cores. The output is still
+in input order. With more than one worker, CSV output is flushed when its
+buffer is full or the input is idle, rather than after each record, to
+reduce writes. The larger of -workers and -exec-concurrency limits the
+records in progress at once.
+
+An input may also be a CIDR, such as 203.0.113.0/28, or a range, such as
+192.0.2.10-192.0.2.20, which is expanded to each of its addresses, such as
+to locate the subnets in a firewall config. Inputs with more addresses than
+-max-expand, 65536 by default, are reported as invalid rather than
+expanded. With -summarize-ranges, consecutive addresses of a CIDR or range
+with the same location are output as a single record whose ip is the
+addresses in CIDR notation, if they form one network, or as first-last, so
+a network that maps to a single location is one row.
+
+Use "iplookupdb update" to download the databases from MaxMind, such as from
+cron, instead of downloading them by hand. Each edition given by -editions,
+GeoLite2-City by default, is downloaded with its published SHA-256 checksum,
+verified, checked to open as a database, and then atomically renamed over
+the database in -dir, such as GeoLite2-City.mmdb, so lookups running at the
+same time never see a partial file. The credentials are taken from the first
+of -account-id with -license-key-file, the MAXMIND_ACCOUNT_ID and
+MAXMIND_LICENSE_KEY environment variables, the -config file in the GeoIP.conf
+format used by geoipupdate, which may also list the EditionIDs, or the OS
+keyring as stored by "iplookupdb auth login".
+
+In the long-running -serve, -grpc, -watch-dir, -follow, and -reopen modes,
+the -db file is reopened when it is replaced, such as weekly by "iplookupdb
+update" or geoipupdate, which is checked every -reload-interval, one minute
+by default, or when the process receives SIGHUP. The new database is swapped
+in once lookups in progress using the old one finish, so no lookups are
+dropped, and the -dupes cache is emptied. If the new file cannot be opened,
+the error is reported and the current database is kept.
+
+Use -extract when the input is free-form text, such as Apache or nginx logs
+or email headers, rather than one IP per line. Every IPv4 and IPv6 address
+found in each line is looked up and output as a record, once per line even
+if it is repeated. Add -dupes collapse to output each IP once with a count
+of the lines it was found in. With -annotate, -extract appends a summary for
+every IP in the line, instead of just the first, and a line with a -filter
+is written with the summaries of the records that match.
+
+Give -db a comma-separated list of databases to fall back through them in
+order, such as a licensed GeoIP2 City database backed by the free GeoLite2
+City database. Each IP is looked up in the first database, and then in each
+of the others in turn until one has a record for it. The other databases
+must be of the type of the first, or Country databases after a City
+database, and are opened and checked when the program starts. The source_db
+column, output when -db lists more than one database, is the file name of
+the database the record came from, and is empty for an IP in none of them.
+source_db is also available to -fields and -filter. Only the first database
+is reloaded when it is updated.
+
+  iplookupdb -db GeoIP2-City.mmdb,GeoLite2-City.mmdb -in ips.txt
+
+The database type is detected from its metadata, so -db may also be a
+Country, ASN, Anonymous-IP, ISP, or Domain database, and the default output
+columns are those of the type, e.g., ip,asn,as_org for an ASN database.
+Use -type to override the detection, such as for a database whose type is
+not recognized. The fields of every type are available to -fields and
+-filter, and are empty for a database of another type.
+
+Use -csv-in to read CSV rows, such as an export from a SIEM, and write
+each row with the output columns for the IP in its -ip-column appended,
+e.g., city, subdivision, and country by default, or the -fields. The
+fields of the row are written as read, keeping their quoting, and the
+-delimiter is used for both reading and writing. Rows without a valid IP
+are written with empty columns. With -header, the first row is a header,
+and the names of the columns are appended to it. Each row must be on a
+single line.
+
+  iplookupdb -csv-in -ip-column 5 -header -in export.csv
+
+Use -template to write each record as a line using a Go text/template, such
+as for syslog, human-readable output, or SQL statements. Each field of the
+record is available by its name in Go style, e.g., .IP, .City, .Country,
+.CountryISO, .Latitude, .IsPrivate, and .ASN with -asn-db, along with the
+fields added by -compute, -enrich, and -script. Unknown values are empty,
+and using a field that does not exist is an error.
+
+  iplookupdb -template '{{.IP}} is in {{.City}}, {{.Country}} ({{.ASN}})' -asn-db GeoLite2-ASN.mmdb 8.8.8.8
+
+Use -summary country, city, or asn to output the number of records for each
+group instead of each record, most common first, like piping the output
+through sort and uniq -c. Countries are grouped by country_iso, cities by
+city and country_iso, and ASNs, which need -asn-db or an ASN -db, by asn and
+as_org. Repeated IPs are served from the -dupes cache, and with -unique each
+IP is counted once. The -filter and other options apply to the records
+before they are counted.
+
+  iplookupdb -summary country -in access.log -extract
+
+To only output IPs from or not from specific places, such as to build a
+blocklist from logs, use -include-country and -exclude-country with ISO
+country codes, -include-continent and -exclude-continent with continent
+codes, e.g., EU, which are also in the continent_code field, and
+-include-asn and -exclude-asn with ASNs, with or without an AS prefix,
+which need -asn-db or an ASN or ISP -db. Each takes a comma-separated list
+and values are compared without regard to case. A record is output if it
+matches every include flag and no exclude flag, and also matches -filter,
+if given. Use -invert to output the records that do not match the include
+and exclude flags instead.
+
+  iplookupdb -extract -exclude-country US,CA -fields ip -unique -in access.log
+
+Use -from latitude,longitude, or -from-ip with an IP whose location is used
+instead, to add the great-circle distance_km from that reference point to
+the location of each IP, such as to flag logins that are implausibly far
+from a user's usual location. The distance is empty for IPs without
+coordinates, and can be used by -filter and the other options like any
+other field.
+
+  iplookupdb -from-ip 203.0.113.7 -filter 'distance_km > 1000' -in logins.txt
+
+Use -units mi to add the distance in miles as distance_mi instead. Use
+-locale with a locale, such as de or fr-CA, to write the coordinates and
+distances of the CSV output with its decimal separator, such as 51,5142 for
+de, for reports read by people who expect it. Fields with a comma are
+quoted, so -delimiter ';' is often used as well. JSON and the other formats
+always use a period.
+
+  iplookupdb -from 51.5,-0.13 -units mi -locale de -delimiter ';' -in logins.txt
+
+Use -rdns to add the hostname of each IP from a reverse DNS (PTR) lookup,
+which together with the location speeds up abuse triage. Each lookup waits
+at most -rdns-timeout, and at most -rdns-concurrency lookups are made at
+once while the output stays in input order. The hostname is empty if the
+IP has no PTR record or the lookup fails or times out. Since DNS changes,
+-rdns cannot be used with -deterministic.
+
+The exit status is 0 when all inputs were looked up, 1 for invalid options,
+2 if a database cannot be opened or is stale, 3 if the input cannot be read,
+4 if the output cannot be written, and 5 if some inputs were invalid or some
+lookups failed, so scripts can tell bad input apart from other errors.
+Invalid inputs and failed lookups are reported on stderr as they occur, with
+a summary of their counts at exit, and processing continues. With -strict,
+the first invalid input or failed lookup stops processing. Inputs skipped by
+-skip-invalid are not errors.
+
+Flags that are not given on the command line take their value from an
+environment variable named for the flag, such as IPLOOKUPDB_DB for -db or
+IPLOOKUPDB_ASN_DB for -asn-db, and otherwise from the config file given by
+-config or IPLOOKUPDB_CONFIG, or iplookupdb/config.toml in the user config
+directory, such as ~/.config, if it exists. So flags take precedence over
+the environment, which takes precedence over the config file. The config
+file uses a subset of TOML with a flag name and value on each line:
+
+  # defaults for iplookupdb
+  db = "/var/lib/GeoIP/GeoLite2-City.mmdb"
+  asn_db = "/var/lib/GeoIP/GeoLite2-ASN.mmdb"
+  fields = "ip,country_iso,asn"
+  workers = 4
+  compute = ["risk=is_private ? 0 : 50"]
+
+Use iplookupdb config check to see the effective value of each flag and
+whether it came from a flag, the environment, the config file, or the
+default.
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+	"github.com/oschwald/geoip2-golang"
+	"golang.org/x/term"
+)
+
+// openInput returns an io.ReadCloser based on the name.
+// If name is empty, then stdin is used.
+func openInput(name string) (io.ReadCloser, error) {
+	if name != "" {
+		return os.Open(name)
+	}
+	return os.Stdin, nil
+}
+
+// openOutput returns an io.WriteCloser based on the name.
+// If name is empty, then stdout is used.
+// If name is provided, the file must not exist, otherwise an error is returned,
+// unless appendTo is true, which appends to the file if it exists.
+func openOutput(name string, appendTo bool) (io.WriteCloser, error) {
+	if name != "" {
+		flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if appendTo {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		return os.OpenFile(name, flag, 0666)
+	}
+	return os.Stdout, nil
+}
+
+// validateLang returns an error if any of the comma-separated languages in
+// lang is not one of the languages listed in the db metadata. The error
+// includes the valid languages to help the user.
+func validateLang(db *geoip2.Reader, lang string) error {
+	langs := db.Metadata().Languages
+	if len(langs) == 0 {
+		return nil
+	}
+	for _, l := range strings.Split(lang, ",") {
+		if !slices.Contains(langs, strings.TrimSpace(l)) {
+			return fmt.Errorf("%q not found in database, valid options are: %s",
+				strings.TrimSpace(l), strings.Join(langs, ", "))
+		}
+	}
+	return nil
+}
+
+// Policies for handling duplicate IPs.
+const (
+	dupesLookup   = "lookup"   // look up every occurrence
+	dupesCache    = "cache"    // serve repeated IPs from a cache
+	dupesCollapse = "collapse" // output one row per IP with a count
+)
+
+// location is the city, subdivision, and country of an IP.
+type location struct {
+	city, subdivision, country string
+	countryISO                 string // ISO 3166-1 country code
+	continent, postal          string
+	continentCode              string // e.g., EU
+	timezone                   string // IANA time zone, e.g., Europe/London
+
+	// coordinates, which are only known if hasCoords is true
+	latitude, longitude float64
+	accuracyRadius      uint16 // in kilometers
+	hasCoords           bool
+
+	// fields of the other database types, which are set by dbType
+	dbType                        string
+	asn, asOrg, isp, organization string
+	domain                        string
+	anonymous                     anonymousFlags
+
+	sourceDB string // base name of the db with the record, if any
+}
+
+// cachedLocation is a location in the cache used by the cache dupes policy.
+type cachedLocation struct {
+	loc location
+	ok  bool
+}
+
+// checkAge returns an error if the db was built more than maxAge ago.
+// If maxAge is zero, then any age is allowed.
+func checkAge(db *geoip2.Reader, maxAge time.Duration) error {
+	if maxAge <= 0 {
+		return nil
+	}
+
+	built := time.Unix(int64(db.Metadata().BuildEpoch), 0)
+	if age := time.Since(built); age > maxAge {
+		return fmt.Errorf("built %s, %s ago, exceeds %s",
+			built.Format(time.DateOnly), age.Round(time.Second), maxAge)
+	}
+	return nil
+}
+
+// processor looks up IPs and writes the results.
+type processor struct {
+	out   io.Writer // the output, used directly by annotate
+	w     *csv.Writer
+	db    geoDB
+	sites siteDB // nil unless the private policy is internal
+	cfg   config
+
+	cache    *locationCache // used by the cache dupes policy
+	cacheGen int            // db generation of the cache
+
+	cacheHits, cacheMisses int
+
+	// run statistics
+	written, invalid, lookupErrors, binary int
+	unique                                 map[netip.Addr]struct{} // nil unless stats
+
+	seen ipSet // IPs already output, nil unless -dedupe
+
+	columns []string     // names of the output columns
+	rw      recordWriter // writes the output records in the output format
+
+	csvHeaderDone bool // the header row of -csv-in has been written
+
+	// used by the collapse dupes policy to hold rows until the end
+	rows   []env
+	counts []int
+	rowFor map[string]int // index of the row for an IP
+
+	records int // number of input records read
+
+	offset      int64 // bytes of input read, used for checkpoints
+	resumeAfter int   // records to skip when resuming without seeking
+
+	start        time.Time        // start of the run
+	progress     <-chan os.Signal // receives requests to print progress
+	progressTick <-chan time.Time // receives each -progress interval
+
+	inputSize   int64 // size of the input file for the ETA, 0 if unknown
+	startOffset int64 // offset of the input at the start of the run
+
+	pending []*pendingRecord // records being enriched, in input order
+
+	metrics *metrics // nil unless the metrics exporter is enabled
+}
+
+// newProcessor returns a processor writing records to out in the output
+// format.
+func newProcessor(out io.Writer, cfg config, db geoDB, sites siteDB) *processor {
+	w := csv.NewWriter(out)
+	w.Comma = cfg.delimiter
+
+	p := &processor{
+		out:   out,
+		w:     w,
+		db:    db,
+		sites: sites,
+		cfg:   cfg,
+		start: time.Now(),
+	}
+	switch cfg.dupes {
+	case dupesCache:
+		p.cache = newLocationCache(cfg.cacheSize)
+	case dupesCollapse:
+		p.rowFor = make(map[string]int)
+	}
+	if cfg.summary != "" {
+		p.rowFor = make(map[string]int)
+	}
+	if cfg.stats || cfg.statsOut != "" {
+		p.unique = make(map[netip.Addr]struct{})
+	}
+	if cfg.dedupe == dedupeMemory {
+		p.seen = memorySet{}
+	}
+	p.columns = p.outputColumns()
+	p.rw = p.newRecordWriter()
+	return p
+}
+
+// processIP will lookup the ipStr provided in db and output the results to w.
+//
+// The output is a comma-separated list of IP Address, city, subdivision
+// (e.g., state for US-based addresses), and county.
+//
+// If the IP is private, then the private policy is applied. By default, the
+// private label is returned for city, subdivision, and county.
+//
+// If city, subdivision, or county is empty, then unknown is used.
+//
+// IPv4-mapped IPv6 addresses are looked up and output as IPv4, unless
+// keepMapped is set, which outputs the IPv6 form.
+//
+// Any port in ipStr is ignored, unless portColumn is set, which outputs the
+// port after the IP Address. The port is empty if ipStr does not have one.
+//
+// If latency is set, the duration of the lookup in microseconds is output
+// after the country, followed by any computed columns, columns added by the
+// external enrichment command, and columns added by the script.
+//
+// Repeated IPs are handled based on the dupes policy.
+//
+// Any errors are displayed on stderr, such as parsing or searching fails,
+// unless the input is not a valid IP and invalid inputs are skipped.
+func (p *processor) processIP(ipStr string) {
+	if p.cfg.clean {
+		ipStr = cleanIP(ipStr)
+	}
+
+	if isRange(ipStr) {
+		p.processRange(ipStr)
+		return
+	}
+
+	addr, port, err := lookup.ParseIP(ipStr)
+	if err != nil {
+		p.invalid++
+		if !p.cfg.skipInvalid {
+			fmt.Fprintf(os.Stderr, "Cannot convert %q to IP\n", strings.TrimSpace(ipStr))
+		}
+		return
+	}
+	p.processAddr(addr, port)
+}
+
+// processAddr looks up addr, with the optional port, and outputs the record.
+func (p *processor) processAddr(addr netip.Addr, port string) {
+	if p.unique != nil {
+		p.unique[addr.Unmap()] = struct{}{}
+	}
+	if p.seen != nil {
+		// on an error, output the IP rather than risk dropping it
+		if added, err := p.seen.add(addr.Unmap()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error deduplicating IP %v: %v\n", addr.Unmap(), err)
+		} else if !added {
+			return
+		}
+	}
+
+	p.checkCache()
+	if p.cfg.workers > 1 && (p.cache == nil || !p.cache.contains(addr.Unmap())) {
+		p.lookupAsync(addr, port)
+		return
+	}
+
+	lookupStart := time.Now()
+	loc, ok, err := p.locate(addr.Unmap())
+	elapsed := time.Since(lookupStart)
+	if err != nil {
+		p.lookupErrors++
+		fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", addr.Unmap(), err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	ipOut := outputAddr(addr, p.cfg.keepMapped)
+	e := recordEnv(ipOut, port, loc)
+	p.enrich(e, func() { p.outputRecord(e, elapsed) })
+}
+
+// lookupAsync looks up and enriches the record for addr with the optional
+// port in the background, for -workers, and outputs it in input order.
+// Results are added to the cache, if any, when output.
+func (p *processor) lookupAsync(addr netip.Addr, port string) {
+	if p.cache != nil {
+		p.cacheMisses++
+	}
+
+	var (
+		loc       location
+		ok        bool
+		err       error
+		elapsed   time.Duration
+		e         env
+		enrichErr error
+	)
+	p.async(func() {
+		lookupStart := time.Now()
+		loc, ok, err = p.lookupLocation(addr.Unmap())
+		elapsed = time.Since(lookupStart)
+		if err == nil && ok {
+			e = recordEnv(outputAddr(addr, p.cfg.keepMapped), port, loc)
+			enrichErr = p.runEnrichers(e)
+		}
+	}, func() {
+		if p.cache != nil && err == nil {
+			p.cache.add(addr.Unmap(), cachedLocation{loc, ok})
+		}
+		if err != nil {
+			p.lookupErrors++
+			fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", addr.Unmap(), err)
+			return
+		}
+		if !ok {
+			return
+		}
+		p.reportEnrichError(e, enrichErr)
+		p.outputRecord(e, elapsed)
+	})
+}
+
+// outputRecord runs the script, computes columns, and applies the filter
+// for the record e, then outputs it. The lookup took elapsed.
+func (p *processor) outputRecord(e env, elapsed time.Duration) {
+	if !p.runScript(e) {
+		return
+	}
+	if _, ok := p.evalRecord(e); !ok {
+		return
+	}
+
+	if p.cfg.latency {
+		e["latency_us"] = float64(elapsed.Nanoseconds()) / 1e3
+	}
+
+	p.observe(e)
+	switch {
+	case p.cfg.summary != "":
+		p.summarize(e)
+		return
+	case p.cfg.dupes == dupesCollapse:
+		p.collapse(formatValue(e["ip"]), e)
+		return
+	}
+
+	p.write(e)
+}
+
+// locate returns the location of addr, which must not be IPv4-mapped.
+// If ok is false, the IP should be skipped.
+func (p *processor) locate(addr netip.Addr) (loc location, ok bool, err error) {
+	p.checkCache()
+	if p.cache != nil {
+		if c, found := p.cache.get(addr); found {
+			p.cacheHits++
+			return c.loc, c.ok, nil
+		}
+		p.cacheMisses++
+	}
+
+	loc, ok, err = p.lookupLocation(addr)
+	if p.cache != nil && err == nil {
+		p.cache.add(addr, cachedLocation{loc, ok})
+	}
+	return loc, ok, err
+}
+
+// checkCache empties the cache, if any, when the db has been reloaded since
+// the cached locations were looked up.
+func (p *processor) checkCache() {
+	if p.cache == nil {
+		return
+	}
+	if gen := dbGeneration(p.db); gen != p.cacheGen {
+		p.cache.clear()
+		p.cacheGen = gen
+	}
+}
+
+// lookupLocation returns the location of addr, which must not be
+// IPv4-mapped, without using the cache. It is safe for concurrent use.
+func (p *processor) lookupLocation(addr netip.Addr) (location, bool, error) {
+	ip := net.IP(addr.AsSlice())
+	if scope := ipScope(addr); scope != scopePublic {
+		return p.privateLocation(ip, scope)
+	}
+	return p.dbLocation(ip)
+}
+
+// dbLocation returns the location of ip found in the db or, if it has no
+// record for ip, in the first fallback db that does.
+func (p *processor) dbLocation(ip net.IP) (location, bool, error) {
+	loc, err := dbTypeLocation(p.db, p.dbType(), ip, p.cfg.lang)
+	if err != nil {
+		return location{}, false, err
+	}
+	if !loc.empty() {
+		loc.sourceDB = filepath.Base(p.cfg.dbName)
+	} else if len(p.cfg.fallbacks) > 0 {
+		if fallback, found, err := p.fallbackLocation(ip); err != nil || found {
+			return fallback, err == nil, err
+		}
+	}
+	return loc, true, nil
+}
+
+// dbTypeLocation returns the location of ip in db of dbType.
+func dbTypeLocation(db geoDB, dbType string, ip net.IP, lang string) (location, error) {
+	if dbType != dbTypeCity {
+		return typeLocation(db, dbType, ip, lang)
+	}
+
+	city, err := db.City(ip)
+	if err != nil {
+		return location{}, err
+	}
+
+	addr, _ := netip.AddrFromSlice(ip)
+	r := lookup.CityRecord(addr, city, lang)
+	return location{
+		city:           r.City,
+		subdivision:    r.Subdivision,
+		country:        r.Country,
+		countryISO:     r.CountryISO,
+		continent:      r.Continent,
+		continentCode:  r.ContinentCode,
+		postal:         r.Postal,
+		timezone:       r.TimeZone,
+		latitude:       r.Latitude,
+		longitude:      r.Longitude,
+		accuracyRadius: r.AccuracyRadius,
+		hasCoords:      r.HasCoordinates,
+	}, nil
+}
+
+// privateLocation returns the location to output for ip, which is private or
+// of another scope that is not public, based on the private policy. It is
+// labeled with the -private-label or, if none, its scope. If ok is false,
+// the ip should be skipped.
+func (p *processor) privateLocation(ip net.IP, scope string) (loc location, ok bool, err error) {
+	text := p.cfg.privateLabel
+	if text == "" {
+		text = scope
+	}
+	label := location{
+		city:        text,
+		subdivision: text,
+		country:     text,
+		countryISO:  text,
+	}
+
+	switch p.cfg.private {
+	case privateSkip:
+		return location{}, false, nil
+	case privateInternal:
+		loc, ok, err := p.sites.lookup(ip, p.cfg.lang)
+		if err != nil || ok {
+			return loc, ok, err
+		}
+	}
+
+	return label, true, nil
+}
+
+// write writes the record e in the output format.
+func (p *processor) write(e env) {
+	p.written++
+	if err := p.rw.write(record{p.columns, e}); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing output:", err)
+	}
+}
+
+// collapse records the record e for ip, which is written by finish with a
+// count of the times ip was seen. Only the first occurrence is kept.
+func (p *processor) collapse(ip string, e env) {
+	if n, found := p.rowFor[ip]; found {
+		p.counts[n]++
+		return
+	}
+
+	p.rowFor[ip] = len(p.rows)
+	p.rows = append(p.rows, e)
+	p.counts = append(p.counts, 1)
+}
+
+// finish writes any rows held until the end of the input and completes the
+// output.
+func (p *processor) finish() {
+	p.drain()
+	if p.cfg.summary != "" {
+		p.sortSummary()
+	}
+	for n, e := range p.rows {
+		e["count"] = float64(p.counts[n])
+		p.write(e)
+	}
+	if err := p.rw.close(); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing output:", err)
+	}
+}
+
+// maxReached reports whether the maximum number of records was processed.
+func (p *processor) maxReached() bool {
+	return p.cfg.max > 0 && p.records >= p.cfg.skip+p.cfg.max
+}
+
+// next counts an input record and reports whether to process it based on
+// the skip and max options. If stop is true, no further records should be
+// read.
+//
+// If progress was requested, such as with SIGUSR1 or by -progress, it is
+// printed first.
+func (p *processor) next() (process, stop bool) {
+	select {
+	case <-p.progress:
+		p.printProgress("progress")
+	case <-p.progressTick:
+		p.printProgress("progress")
+	default:
+	}
+
+	if p.maxReached() || p.cfg.strict && p.failed() {
+		return false, true
+	}
+
+	p.records++
+	if p.records <= p.cfg.skip || p.records <= p.resumeAfter {
+		return false, false
+	}
+	return true, false
+}
+
+// processRecord processes an input record, which is an IP or, with
+// annotate, a line to annotate.
+func (p *processor) processRecord(record string) {
+	switch {
+	case p.cfg.annotate:
+		p.annotateLine(record)
+		return
+	case p.cfg.events:
+		p.processEvent(record)
+		return
+	case p.cfg.extract:
+		p.extractLine(record)
+		return
+	case p.cfg.csvIn:
+		p.processCSVRow(record)
+		return
+	}
+	p.processIP(record)
+}
+
+func (p *processor) processIPsFromArgs(args []string) {
+	for index := range args {
+		process, stop := p.next()
+		if stop {
+			break
+		}
+		if process {
+			p.processRecord(args[index])
+		}
+		p.saveCheckpoint(false)
+	}
+}
+
+// processIPsFromInput processes each line of r as a record, returning any
+// error reading r.
+func (p *processor) processIPsFromInput(r io.ReadCloser) error {
+	scanner := bufio.NewScanner(&binaryChecker{r: r})
+
+	// track the end of each line for checkpoints
+	end := p.offset
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		end += int64(advance)
+		return advance, token, err
+	})
+
+	for scanner.Scan() {
+		process, stop := p.next()
+		if stop {
+			break
+		}
+		if process {
+			line := scanner.Text()
+			if isBinary(line) {
+				line, process = p.binaryRecord(line)
+			}
+			if process {
+				p.processRecord(line)
+			}
+		}
+		p.offset = end
+		p.saveCheckpoint(false)
+	}
+	if p.binary > 1 {
+		fmt.Fprintf(os.Stderr, "%d input records contained binary data\n", p.binary)
+	}
+	return scanner.Err()
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, found := commands[os.Args[1]]; found {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+	os.Exit(run())
+}
+
+// run looks up the IPs given by the command-line flags and returns the exit
+// status. Returning, rather than calling os.Exit, runs the deferred calls
+// that complete the output and release the databases and temporary files.
+func run() int {
+	cfg, err := parseFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid option: %v\n", err)
+		flag.Usage()
+		return exitUsage
+	}
+
+	v := cfg.verbose
+	v.config(flag.CommandLine)
+	runStart := time.Now()
+	start := runStart
+
+	if cfg.validate {
+		if validate(os.Stderr, cfg, flag.Args()) > 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	db, err := geoip2.Open(cfg.dbName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return exitDB
+	}
+	defer db.Close()
+	v.metadata(cfg.dbName, db)
+
+	if cfg.listLangs {
+		for _, lang := range db.Metadata().Languages {
+			fmt.Println(lang)
+		}
+		return exitOK
+	}
+
+	if err := validateLang(db, cfg.lang); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid language: %v\n", err)
+		return exitUsage
+	}
+
+	cfg.dbType, err = resolveDBType(db, cfg.dbType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid database: %v\n", err)
+		return exitDB
+	}
+
+	cfg.fallbacks, err = openFallbacks(cfg.fallbackNames, cfg.dbType, cfg.lang, v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid fallback database: %v\n", err)
+		return exitDB
+	}
+	defer closeFallbacks(cfg.fallbacks)
+
+	if cfg.distance != nil {
+		if err := cfg.distance.resolve(db); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -from-ip: %v\n", err)
+			return exitUsage
+		}
+	}
+
+	if err := checkAge(db, cfg.maxAge); err != nil {
+		fmt.Fprintf(os.Stderr, "Stale database: %v\n", err)
+		return exitDB
+	}
+
+	if err := openEnrichers(cfg.enrichers); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return exitDB
+	}
+	defer closeEnrichers(cfg.enrichers)
+
+	var sites siteDB
+	if cfg.private == privateInternal {
+		sites, err = openSiteDB(cfg.privateDB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open private database: %v\n", err)
+			return exitDB
+		}
+		defer sites.Close()
+	}
+	start = v.phase("opening databases", start)
+
+	var lookupDB geoDB = db
+	if cfg.watchDir != "" || cfg.serve != "" || cfg.grpc != "" || cfg.follow || cfg.reopen {
+		lookupDB = newReloadingDB(cfg.dbName, db, cfg.lang, v, cfg.reloadInterval, reloadSignal())
+	}
+
+	if cfg.watchDir != "" {
+		restore := setupConsole()
+		defer restore()
+
+		if err := watchDir(cfg.watchDir, cfg, lookupDB, sites); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to watch directory: %v\n", err)
+			return exitInput
+		}
+		return exitOK
+	}
+
+	if cfg.serve != "" || cfg.grpc != "" {
+		if err := serve(cfg, lookupDB, sites); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to serve: %v\n", err)
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	var input io.ReadCloser = os.Stdin
+	if !cfg.follow && !cfg.reopen {
+		input, err = openInput(cfg.inputName)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open input: %v\n", err)
+		return exitInput
+	}
+	defer input.Close()
+
+	output, err := openOutput(cfg.outputName, cfg.resume)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open output: %v\n", err)
+		return exitOutput
+	}
+	defer output.Close()
+
+	restore := setupConsole()
+	defer restore()
+
+	encOutput, err := encodeOutput(output, cfg.encoding, !cfg.resume)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write output: %v\n", err)
+		return exitOutput
+	}
+
+	start = v.phase("opening input and output", start)
+
+	p := newProcessor(encOutput, cfg, lookupDB, sites)
+	p.start = runStart
+	p.progress = progressSignal()
+
+	if cfg.dedupe == dedupeDisk {
+		set, err := newDiskSet("")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create -dedupe file: %v\n", err)
+			return exitOutput
+		}
+		defer set.close()
+		p.seen = set
+	}
+
+	if cfg.metricsAddr != "" {
+		p.metrics = newMetrics(cfg.metricsLabels)
+		if err := serveMetrics(cfg.metricsAddr, p.metrics); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to serve metrics: %v\n", err)
+			return exitUsage
+		}
+	}
+
+	if cfg.resume {
+		cp, err := readCheckpoint(cfg.checkpoint)
+		if err == nil {
+			err = p.resume(cp, input)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to resume: %v\n", err)
+			return exitInput
+		}
+		v.printf("resuming after %d records", cp.Records)
+	}
+
+	if cfg.progress {
+		ticker := time.NewTicker(cfg.progressInterval)
+		defer ticker.Stop()
+		p.progressTick = ticker.C
+		p.inputSize, p.startOffset = inputSize(input), p.offset
+	}
+
+	var inputErr error // reading the input failed, after processing the lines read
+	args := flag.Args()
+	if len(args) > 0 {
+		p.processIPsFromArgs(args)
+
+	} else if isNamedPipe(cfg.inputName) && (cfg.follow || cfg.reopen) {
+		if err := p.readPipe(cfg.inputName); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read input: %v\n", err)
+			return exitInput
+		}
+
+	} else if cfg.reopen {
+		fmt.Fprintf(os.Stderr, "Invalid option: -reopen requires -in to be a named pipe\n")
+		return exitUsage
+
+	} else if cfg.follow {
+		if err := p.follow(cfg.inputName); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to follow input: %v\n", err)
+			return exitInput
+		}
+
+	} else {
+		// only prompt when typing, not when stdin is a pipe or file
+		if cfg.inputName == "" && term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Printf("Please provide IPs, one per line:\n")
+		}
+
+		if inputErr = p.processIPsFromInput(input); inputErr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read input: %v\n", inputErr)
+		}
+	}
+
+	p.finish()
+	if err := flushEncoding(encOutput); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write output: %v\n", err)
+		return exitOutput
+	}
+	p.saveCheckpoint(true)
+	if cfg.alerter != nil {
+		cfg.alerter.wait()
+	}
+	if cfg.progress {
+		p.printProgress("done")
+	}
+	v.phase(fmt.Sprintf("processing %d records", p.records), start)
+
+	if p.cache != nil {
+		v.printf("cache: %d entries, %d hits, %d misses",
+			p.cache.len(), p.cacheHits, p.cacheMisses)
+	}
+
+	if cfg.stats || cfg.statsOut != "" {
+		if err := writeStats(p.stats(p.start), cfg.statsOut); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write stats: %v\n", err)
+		}
+	}
+
+	if p.failed() {
+		p.printErrorSummary()
+	}
+	switch {
+	case inputErr != nil:
+		return exitInput
+	case p.failed():
+		return exitLookupErrors
+	}
+	return exitOK
+}
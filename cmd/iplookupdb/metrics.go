@@ -0,0 +1,110 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultMetricsLabels are the default labels of the records counter.
+const defaultMetricsLabels = "country_iso"
+
+// metrics counts the output records by the values of record fields, such as
+// country, for a Prometheus exporter.
+type metrics struct {
+	labels []string // names of the fields used as labels
+
+	mu     sync.Mutex
+	counts map[string]int      // by the joined label values
+	values map[string][]string // label values of each key of counts
+}
+
+// newMetrics returns metrics counting records by the fields labels.
+func newMetrics(labels []string) *metrics {
+	return &metrics{
+		labels: labels,
+		counts: make(map[string]int),
+		values: make(map[string][]string),
+	}
+}
+
+// observe counts the record e.
+func (m *metrics) observe(e env) {
+	values := make([]string, len(m.labels))
+	for n, name := range m.labels {
+		values[n] = formatValue(e[name])
+	}
+	key := strings.Join(values, "\x00")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.values[key]; !found {
+		m.values[key] = values
+	}
+	m.counts[key]++
+}
+
+// write writes the metrics to w in the Prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.counts))
+	for key := range m.counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(w, "# HELP iplookupdb_records_total Records output, by location and other record fields.")
+	fmt.Fprintln(w, "# TYPE iplookupdb_records_total counter")
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for _, key := range keys {
+		labels := make([]string, len(m.labels))
+		for n, name := range m.labels {
+			labels[n] = name + `="` + escape.Replace(m.values[key][n]) + `"`
+		}
+		fmt.Fprintf(w, "iplookupdb_records_total{%s} %d\n", strings.Join(labels, ","), m.counts[key])
+	}
+}
+
+// serveMetrics listens on addr and serves the metrics in the background.
+// The metrics are served at /metrics in the Prometheus text format, and the
+// counts are also served using the Grafana simple JSON datasource contract
+// at /, /search, and /query.
+func serveMetrics(addr string, m *metrics) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.write(w)
+	})
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("OK\n"))
+	})
+	mux.HandleFunc("POST /search", m.grafanaSearch)
+	mux.HandleFunc("POST /query", m.grafanaQuery)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go http.Serve(l, mux)
+	return nil
+}
+
+// observe counts the output record e in the metrics and sends an alert if
+// it matches the alert filter, if enabled.
+func (p *processor) observe(e env) {
+	if p.metrics != nil {
+		p.metrics.observe(e)
+	}
+	if p.cfg.alerter != nil {
+		p.cfg.alerter.alert(e)
+	}
+}
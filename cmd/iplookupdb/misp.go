@@ -0,0 +1,91 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// mispEvent is a MISP event in the format used by the MISP REST API and
+// event import.
+type mispEvent struct {
+	Info          string          `json:"info"`
+	Date          string          `json:"date"`
+	ThreatLevelID string          `json:"threat_level_id"`
+	Analysis      string          `json:"analysis"`
+	Distribution  string          `json:"distribution"`
+	Attribute     []mispAttribute `json:"Attribute"`
+}
+
+// mispAttribute is an attribute of a MISP event.
+type mispAttribute struct {
+	Type     string    `json:"type"`
+	Category string    `json:"category"`
+	Value    string    `json:"value"`
+	ToIDS    bool      `json:"to_ids"`
+	Comment  string    `json:"comment,omitempty"`
+	Tag      []mispTag `json:"Tag,omitempty"`
+}
+
+// mispTag is a tag of a MISP attribute.
+type mispTag struct {
+	Name string `json:"name"`
+}
+
+// mispGeoFields are the record fields added as tags of the form
+// geo:name="value".
+var mispGeoFields = []string{"country_iso", "country", "subdivision", "city"}
+
+// writeMISP writes the records as the ip-src attributes of a MISP event
+// dated date, with the location and any extra columns as tags.
+func writeMISP(w io.Writer, records []env, extra []string, date time.Time) error {
+	event := mispEvent{
+		Info:          "IP addresses geolocated by iplookupdb",
+		Date:          date.Format(time.DateOnly),
+		ThreatLevelID: "4", // undefined
+		Analysis:      "2", // completed
+		Distribution:  "0", // your organization only
+		Attribute:     []mispAttribute{},
+	}
+
+	for _, e := range records {
+		a := mispAttribute{
+			Type:     "ip-src",
+			Category: "Network activity",
+			Value:    formatValue(e["ip"]),
+			ToIDS:    true,
+		}
+
+		var place []string
+		for _, name := range []string{"city", "subdivision", "country"} {
+			if s := formatValue(e[name]); s != "" {
+				place = append(place, s)
+			}
+		}
+		a.Comment = strings.Join(place, ", ")
+
+		for _, name := range mispGeoFields {
+			if s := formatValue(e[name]); s != "" {
+				a.Tag = append(a.Tag, mispTag{fmt.Sprintf("geo:%s=%q", name, s)})
+			}
+		}
+		for _, name := range extra {
+			if s := formatValue(e[name]); s != "" {
+				a.Tag = append(a.Tag, mispTag{fmt.Sprintf("iplookupdb:%s=%q", name, s)})
+			}
+		}
+
+		event.Attribute = append(event.Attribute, a)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Event mispEvent `json:"Event"`
+	}{event})
+}
@@ -0,0 +1,255 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// MRT types and subtypes of RIB dumps from RFC 6396.
+const (
+	mrtTableDumpV2     = 13
+	mrtRIBIPv4Unicast  = 2
+	mrtRIBIPv6Unicast  = 4
+	bgpAttrASPath      = 2
+	bgpAttrExtendedLen = 0x10
+	bgpASSequence      = 2
+)
+
+// errMRTTruncated is returned for MRT records shorter than their contents.
+var errMRTTruncated = errors.New("truncated MRT record")
+
+// mrtRoute is a prefix in a RIB dump and the ASN that originated it.
+type mrtRoute struct {
+	prefix netip.Prefix
+	origin uint32 // 0 if unknown
+}
+
+// readMRT calls fn with each route in the MRT TABLE_DUMP_V2 RIB dump r.
+// Records of other types, such as the peer index table, are skipped.
+func readMRT(r io.Reader, fn func(mrtRoute)) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		typ := binary.BigEndian.Uint16(header[4:])
+		subtype := binary.BigEndian.Uint16(header[6:])
+		body := make([]byte, binary.BigEndian.Uint32(header[8:]))
+		if _, err := io.ReadFull(br, body); err != nil {
+			return errMRTTruncated
+		}
+
+		if typ != mrtTableDumpV2 {
+			continue
+		}
+		var bits int
+		switch subtype {
+		case mrtRIBIPv4Unicast:
+			bits = 32
+		case mrtRIBIPv6Unicast:
+			bits = 128
+		default:
+			continue
+		}
+
+		route, err := parseRIB(body, bits)
+		if err != nil {
+			return err
+		}
+		fn(route)
+	}
+}
+
+// parseRIB returns the route in the body of a RIB_IPV4_UNICAST or
+// RIB_IPV6_UNICAST record for addresses of size bits, using the origin of
+// the first RIB entry.
+func parseRIB(b []byte, bits int) (mrtRoute, error) {
+	// sequence number, then the prefix length and the prefix
+	if len(b) < 5 {
+		return mrtRoute{}, errMRTTruncated
+	}
+	length := int(b[4])
+	n := (length + 7) / 8
+	if length > bits || len(b) < 5+n+2 {
+		return mrtRoute{}, errMRTTruncated
+	}
+	addr := make([]byte, bits/8)
+	copy(addr, b[5:5+n])
+	ip, _ := netip.AddrFromSlice(addr)
+	route := mrtRoute{prefix: netip.PrefixFrom(ip, length).Masked()}
+
+	// entry count, then entries of peer index, originated time, and
+	// attributes
+	b = b[5+n:]
+	if binary.BigEndian.Uint16(b) == 0 {
+		return route, nil
+	}
+	b = b[2:]
+	if len(b) < 8 {
+		return mrtRoute{}, errMRTTruncated
+	}
+	attrLen := int(binary.BigEndian.Uint16(b[6:]))
+	if len(b) < 8+attrLen {
+		return mrtRoute{}, errMRTTruncated
+	}
+	route.origin = originAS(b[8 : 8+attrLen])
+	return route, nil
+}
+
+// originAS returns the origin AS in the BGP path attributes b, which is the
+// last AS of the AS_PATH, or 0 if there is none. AS numbers are 4 bytes in
+// TABLE_DUMP_V2.
+func originAS(b []byte) uint32 {
+	for len(b) >= 3 {
+		flags, typ := b[0], b[1]
+		var length, hdr int
+		if flags&bgpAttrExtendedLen != 0 {
+			if len(b) < 4 {
+				return 0
+			}
+			length, hdr = int(binary.BigEndian.Uint16(b[2:])), 4
+		} else {
+			length, hdr = int(b[2]), 3
+		}
+		if len(b) < hdr+length {
+			return 0
+		}
+		value := b[hdr : hdr+length]
+		b = b[hdr+length:]
+		if typ != bgpAttrASPath {
+			continue
+		}
+
+		var origin uint32
+		for len(value) >= 2 {
+			count := int(value[1])
+			if len(value) < 2+4*count {
+				return 0
+			}
+			if count > 0 {
+				// for an AS_SET the origin is ambiguous; use its last AS
+				origin = binary.BigEndian.Uint32(value[2+4*(count-1):])
+			}
+			value = value[2+4*count:]
+		}
+		return origin
+	}
+	return 0
+}
+
+// openCompressed opens the file name, decompressing it if it ends in .gz or
+// .bz2, as MRT dumps are usually published.
+func openCompressed(name string) (io.Reader, io.Closer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz":
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return zr, f, nil
+	case ".bz2":
+		return bzip2.NewReader(f), f, nil
+	}
+	return f, f, nil
+}
+
+// runMRT runs the mrt subcommand, which reads MRT RIB dumps, such as from
+// RouteViews or RIPE RIS, and writes a CSV row for each announced prefix
+// with its origin AS and the location of the first address of the prefix
+// as a representative address.
+func runMRT(args []string) int {
+	fs := flag.NewFlagSet("mrt", flag.ContinueOnError)
+	dbName := fs.String("db", "GeoLite2-City.mmdb", "Path to the GeoLite2 City database")
+	lang := fs.String("lang", "en", "Language for GeoIP lookup results.")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: iplookupdb mrt [-db database] [-lang lang] file ...")
+		return exitUsage
+	}
+
+	db, err := geoip2.Open(*dbName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return exitDB
+	}
+	defer db.Close()
+
+	cfg := config{lang: *lang, private: privateLabel}
+	p := newProcessor(io.Discard, cfg, db, nil)
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"prefix", "origin_asn", "ip", "city", "subdivision", "country"})
+
+	seen := make(map[netip.Prefix]bool)
+	write := func(route mrtRoute) {
+		if seen[route.prefix] {
+			return
+		}
+		seen[route.prefix] = true
+
+		addr := route.prefix.Addr()
+		loc, _, err := p.locate(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", addr, err)
+		}
+		asn := ""
+		if route.origin != 0 {
+			asn = strconv.FormatUint(uint64(route.origin), 10)
+		}
+		fields := []string{route.prefix.String(), asn, addr.String(), loc.city, loc.subdivision, loc.country}
+		for n := range fields {
+			if fields[n] == "" {
+				fields[n] = "unknown"
+			}
+		}
+		w.Write(fields)
+	}
+
+	for _, name := range fs.Args() {
+		r, c, err := openCompressed(name)
+		if err == nil {
+			err = readMRT(r, write)
+			c.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", name, err)
+			return exitInput
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write output: %v\n", err)
+		return exitOutput
+	}
+	return exitOK
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"slices"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRowGroup is the number of rows in each row group of a Parquet file.
+const parquetRowGroup = 100000
+
+// parquetDoubles and parquetInts are the columns written as DOUBLE and INT64
+// rather than as strings.
+var (
+	parquetDoubles = []string{"latitude", "longitude", "distance_km", "distance_mi", "latency_us"}
+	parquetInts    = []string{"accuracy_radius", "count"}
+)
+
+// parquetBools are the columns written as BOOLEAN rather than as strings.
+var parquetBools = []string{
+	"is_private", "is_anonymous", "is_anonymous_vpn", "is_hosting_provider",
+	"is_public_proxy", "is_residential_proxy", "is_tor_exit_node", "is_vpn",
+	"is_tor", "is_hosting", "is_proxy", "is_datacenter",
+}
+
+// parquetType returns the Parquet type of the column name. Other columns,
+// including computed columns, are strings.
+func parquetType(name string) parquet.Node {
+	switch {
+	case slices.Contains(parquetDoubles, name):
+		return parquet.Leaf(parquet.DoubleType)
+	case slices.Contains(parquetInts, name):
+		return parquet.Int(64)
+	case slices.Contains(parquetBools, name):
+		return parquet.Leaf(parquet.BooleanType)
+	}
+	return parquet.String()
+}
+
+// parquetWriter writes each record as a row of a Parquet file. Every column
+// is optional, and unknown values, such as coordinates or empty names, are
+// null. The columns are in name order, as required by Parquet groups.
+type parquetWriter struct {
+	w       *parquet.Writer
+	schema  *parquet.Schema
+	columns []string
+	b       *parquet.RowBuilder
+	rows    []parquet.Row // buffered until flush
+}
+
+// newParquetWriter returns a writer of the columns to w.
+func newParquetWriter(w io.Writer, columns []string) *parquetWriter {
+	group := make(parquet.Group, len(columns))
+	for _, name := range columns {
+		group[name] = parquet.Optional(parquetType(name))
+	}
+	schema := parquet.NewSchema("record", group)
+
+	return &parquetWriter{
+		w: parquet.NewWriter(w, schema,
+			parquet.Compression(&parquet.Snappy),
+			parquet.PageBufferSize(1<<20)),
+		schema:  schema,
+		columns: columns,
+		b:       parquet.NewRowBuilder(schema),
+	}
+}
+
+func (pw *parquetWriter) write(r record) error {
+	pw.b.Reset()
+	for _, name := range pw.columns {
+		leaf, _ := pw.schema.Lookup(name)
+		if v := parquetValue(name, r.fields[name]); !v.IsNull() {
+			pw.b.Add(leaf.ColumnIndex, v)
+		}
+	}
+	pw.rows = append(pw.rows, pw.b.Row())
+	if len(pw.rows) >= parquetRowGroup {
+		return pw.flush()
+	}
+	return nil
+}
+
+// parquetValue returns the Parquet value of v in the column name, which is
+// null if v is unknown or not of the type of the column.
+func parquetValue(name string, v any) parquet.Value {
+	switch {
+	case slices.Contains(parquetDoubles, name):
+		if f, ok := v.(float64); ok {
+			return parquet.DoubleValue(f)
+		}
+	case slices.Contains(parquetInts, name):
+		if f, ok := v.(float64); ok {
+			return parquet.Int64Value(int64(f))
+		}
+	case slices.Contains(parquetBools, name):
+		if b, ok := v.(bool); ok {
+			return parquet.BooleanValue(b)
+		}
+	default:
+		if s := formatValue(v); s != "" {
+			return parquet.ByteArrayValue([]byte(s))
+		}
+	}
+	return parquet.NullValue()
+}
+
+// flush writes the buffered rows as a row group.
+func (pw *parquetWriter) flush() error {
+	if len(pw.rows) == 0 {
+		return nil
+	}
+	_, err := pw.w.WriteRows(pw.rows)
+	pw.rows = pw.rows[:0]
+	if err != nil {
+		return err
+	}
+	return pw.w.Flush()
+}
+
+func (pw *parquetWriter) close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	return pw.w.Close()
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// prefixEnricher adds the covering announced prefix and its origin AS to
+// each record, from a CAIDA pfx2as file or an ASN database such as
+// GeoLite2-ASN.
+type prefixEnricher struct {
+	name    string      // of the pfx2as file or ASN database, opened by open
+	origins prefixTable // from a pfx2as file, the origin AS of each prefix
+
+	db *maxminddb.Reader // ASN database, used if not nil
+}
+
+// newPrefixEnricher returns an enricher using the pfx2as file or, if name
+// ends in .mmdb, the ASN database name.
+func newPrefixEnricher(name string) *prefixEnricher {
+	return &prefixEnricher{name: name}
+}
+
+// open opens the ASN database or reads the pfx2as file.
+func (x *prefixEnricher) open() error {
+	if err := x.load(); err != nil {
+		return fmt.Errorf("-pfx2as: %w", err)
+	}
+	return nil
+}
+
+// load opens the ASN database or reads the pfx2as file.
+func (x *prefixEnricher) load() error {
+	name := x.name
+	if strings.EqualFold(filepath.Ext(name), ".mmdb") {
+		db, err := maxminddb.Open(name)
+		if err != nil {
+			return err
+		}
+		x.db = db
+		return nil
+	}
+
+	r, c, err := openCompressed(name)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		// each line is the prefix address, length, and origin AS, which is
+		// written as a_b for multiple origins and a,b for an AS set
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			return fmt.Errorf("%s:%d: expected prefix, length, and AS", name, line)
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		bits, err := strconv.Atoi(fields[1])
+		if err != nil || bits < 0 || bits > addr.BitLen() {
+			return fmt.Errorf("%s:%d: invalid prefix length %q", name, line, fields[1])
+		}
+		x.origins.add(netip.PrefixFrom(addr, bits), fields[2])
+	}
+	return scanner.Err()
+}
+
+// lookup returns the most specific prefix containing addr and its origin AS.
+func (x *prefixEnricher) lookup(addr netip.Addr) (netip.Prefix, string, bool, error) {
+	if x.db != nil {
+		var record struct {
+			ASN uint `maxminddb:"autonomous_system_number"`
+		}
+		network, ok, err := x.db.LookupNetwork(net.IP(addr.AsSlice()), &record)
+		if err != nil || !ok || record.ASN == 0 {
+			return netip.Prefix{}, "", false, err
+		}
+		a, _ := netip.AddrFromSlice(network.IP)
+		ones, _ := network.Mask.Size()
+		return netip.PrefixFrom(a.Unmap(), ones), strconv.FormatUint(uint64(record.ASN), 10), true, nil
+	}
+
+	prefix, origin, ok := x.origins.lookup(addr)
+	return prefix, origin, ok, nil
+}
+
+func (x *prefixEnricher) addedColumns() []string {
+	return []string{"prefix", "origin_as"}
+}
+
+// enrich adds the prefix and origin_as fields to the record e, which are
+// empty if the IP is not in an announced prefix.
+func (x *prefixEnricher) enrich(e env) error {
+	e["prefix"], e["origin_as"] = "", ""
+
+	addr, err := netip.ParseAddr(formatValue(e["ip"]))
+	if err != nil {
+		return nil // changed by an earlier enricher
+	}
+	prefix, origin, ok, err := x.lookup(addr.Unmap())
+	if err != nil || !ok {
+		return err
+	}
+	e["prefix"], e["origin_as"] = prefix.String(), origin
+	return nil
+}
+
+// Close closes the ASN database, if any.
+func (x *prefixEnricher) Close() error {
+	if x.db != nil {
+		return x.db.Close()
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"net/netip"
+	"slices"
+)
+
+// prefixTable maps prefixes to values, finding the most specific prefix
+// containing an address.
+type prefixTable struct {
+	values  map[int]map[netip.Prefix]string // by prefix length
+	lengths []int                           // prefix lengths in values, longest first
+}
+
+// add adds prefix with value v. If prefix was already added, the first
+// value is kept.
+func (t *prefixTable) add(prefix netip.Prefix, v string) {
+	if t.values == nil {
+		t.values = make(map[int]map[netip.Prefix]string)
+	}
+
+	prefix = prefix.Masked()
+	prefixes, found := t.values[prefix.Bits()]
+	if !found {
+		prefixes = make(map[netip.Prefix]string)
+		t.values[prefix.Bits()] = prefixes
+		n, _ := slices.BinarySearchFunc(t.lengths, prefix.Bits(), func(a, b int) int { return b - a })
+		t.lengths = slices.Insert(t.lengths, n, prefix.Bits())
+	}
+	if _, found := prefixes[prefix]; !found {
+		prefixes[prefix] = v
+	}
+}
+
+// lookup returns the most specific prefix containing addr and its value.
+func (t *prefixTable) lookup(addr netip.Addr) (netip.Prefix, string, bool) {
+	for _, bits := range t.lengths {
+		if bits > addr.BitLen() {
+			continue
+		}
+		prefix, _ := addr.Prefix(bits)
+		if v, found := t.values[bits][prefix]; found {
+			return prefix, v, true
+		}
+	}
+	return netip.Prefix{}, "", false
+}
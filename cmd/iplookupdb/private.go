@@ -0,0 +1,145 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Policies for handling private IP addresses.
+const (
+	privateLabel    = "label"    // replace the location with a label
+	privateSkip     = "skip"     // omit private IPs from the output
+	privateInternal = "internal" // look up private IPs in an internal sites db
+)
+
+// siteDB maps private networks to the location of internal sites.
+type siteDB interface {
+	// lookup returns the site for ip in the given lang, which may be a
+	// comma-separated list of languages to try in order.
+	// If ip is not found, ok is false.
+	lookup(ip net.IP, lang string) (loc location, ok bool, err error)
+	io.Closer
+}
+
+// openSiteDB opens the internal sites database name.
+// Files ending in .csv are read as CSV, all others are opened as a MMDB.
+func openSiteDB(name string) (siteDB, error) {
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		return openSiteCSV(name)
+	}
+
+	r, err := maxminddb.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &siteMMDB{r}, nil
+}
+
+// siteMMDB is a siteDB backed by a MMDB using the GeoIP2 City layout, which
+// allows the same tools used to build GeoIP2 databases to build the sites db.
+type siteMMDB struct {
+	*maxminddb.Reader
+}
+
+func (db *siteMMDB) lookup(ip net.IP, lang string) (location, bool, error) {
+	var record geoip2.City
+	_, ok, err := db.LookupNetwork(ip, &record)
+	if err != nil || !ok {
+		return location{}, false, err
+	}
+
+	s := location{
+		city:       lookup.Name(record.City.Names, lang),
+		country:    lookup.Name(record.Country.Names, lang),
+		countryISO: record.Country.IsoCode,
+	}
+	if len(record.Subdivisions) > 0 {
+		s.subdivision = lookup.Name(record.Subdivisions[0].Names, lang)
+	}
+	return s, true, nil
+}
+
+// siteCSV is a siteDB read from a CSV file.
+type siteCSV struct {
+	networks []*net.IPNet
+	sites    []location
+}
+
+// openSiteCSV reads the internal sites from the CSV file name.
+//
+// Each record is network,city,subdivision,country where network is in CIDR
+// notation, e.g., 10.1.0.0/16,Dallas,Texas,United States. Blank lines and
+// lines starting with # are ignored.
+func openSiteCSV(name string) (*siteCSV, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(bytes.NewReader(b))
+	r.Comment = '#'
+	r.FieldsPerRecord = 4
+	r.TrimLeadingSpace = true
+
+	db := &siteCSV{}
+	for {
+		fields, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		_, network, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+
+		db.networks = append(db.networks, network)
+		db.sites = append(db.sites, location{
+			city:        fields[1],
+			subdivision: fields[2],
+			country:     fields[3],
+		})
+	}
+
+	return db, nil
+}
+
+// lookup returns the site with the most specific network containing ip.
+func (db *siteCSV) lookup(ip net.IP, _ string) (location, bool, error) {
+	best, bestOnes := -1, -1
+	for n, network := range db.networks {
+		if !network.Contains(ip) {
+			continue
+		}
+		if ones, _ := network.Mask.Size(); ones > bestOnes {
+			best, bestOnes = n, ones
+		}
+	}
+
+	if best < 0 {
+		return location{}, false, nil
+	}
+	return db.sites[best], true, nil
+}
+
+func (db *siteCSV) Close() error {
+	return nil
+}
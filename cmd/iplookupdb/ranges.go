@@ -0,0 +1,156 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultMaxExpand is the default maximum number of addresses a CIDR or
+// range input is expanded to.
+const defaultMaxExpand = 65536
+
+// isRange reports whether s looks like a CIDR, such as 203.0.113.0/28, or a
+// range, such as 192.0.2.10-192.0.2.20, rather than a single IP.
+func isRange(s string) bool {
+	return strings.ContainsAny(s, "/-")
+}
+
+// parseRange parses s as a CIDR or a range of IPs and returns the first and
+// last addresses. The last address of a range may not be before the first.
+func parseRange(s string) (first, last netip.Addr, err error) {
+	s = strings.TrimSpace(s)
+
+	if from, to, found := strings.Cut(s, "-"); found {
+		first, err = netip.ParseAddr(strings.TrimSpace(from))
+		if err != nil {
+			return netip.Addr{}, netip.Addr{}, err
+		}
+		last, err = netip.ParseAddr(strings.TrimSpace(to))
+		if err != nil {
+			return netip.Addr{}, netip.Addr{}, err
+		}
+		first, last = first.Unmap(), last.Unmap()
+		switch {
+		case first.Zone() != "" || last.Zone() != "":
+			return netip.Addr{}, netip.Addr{}, errors.New("IPv6 zones are not supported")
+		case first.Is4() != last.Is4():
+			return netip.Addr{}, netip.Addr{}, errors.New("range mixes IPv4 and IPv6")
+		case last.Less(first):
+			return netip.Addr{}, netip.Addr{}, errors.New("range ends before it starts")
+		}
+		return first, last, nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	prefix = prefix.Masked()
+	return prefix.Addr(), lastAddr(prefix), nil
+}
+
+// lastAddr returns the last address in prefix, which must be masked.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for n := prefix.Bits(); n < len(b)*8; n++ {
+		b[n/8] |= 0x80 >> (n % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// rangeString returns the range from first to last in CIDR notation if it
+// is a single prefix and as first-last otherwise.
+func rangeString(first, last netip.Addr) string {
+	for bits := 0; bits <= first.BitLen(); bits++ {
+		prefix := netip.PrefixFrom(first, bits)
+		if prefix.Masked().Addr() == first && lastAddr(prefix.Masked()) == last {
+			return prefix.String()
+		}
+	}
+	return first.String() + "-" + last.String()
+}
+
+// rangeSize returns the number of addresses from first to last, or limit+1
+// if there are more than limit.
+func rangeSize(first, last netip.Addr, limit int) int {
+	n := 1
+	for addr := first; addr != last && n <= limit; addr = addr.Next() {
+		n++
+	}
+	return n
+}
+
+// processRange looks up each address of the CIDR or range s. With
+// -summarize-ranges, consecutive addresses with the same location are
+// output as a single record whose ip is the addresses as a CIDR or range.
+func (p *processor) processRange(s string) {
+	first, last, err := parseRange(s)
+	if err == nil && rangeSize(first, last, p.cfg.maxExpand) > p.cfg.maxExpand {
+		err = fmt.Errorf("more than -max-expand %d addresses", p.cfg.maxExpand)
+	}
+	if err != nil {
+		p.invalid++
+		if !p.cfg.skipInvalid {
+			fmt.Fprintf(os.Stderr, "Cannot expand %q: %v\n", strings.TrimSpace(s), err)
+		}
+		return
+	}
+
+	if !p.cfg.summarizeRanges {
+		for addr := first; ; addr = addr.Next() {
+			p.processAddr(addr, "")
+			if addr == last {
+				return
+			}
+		}
+	}
+
+	var (
+		start   netip.Addr // first address of the current group
+		prev    netip.Addr // last address of the current group
+		group   cachedLocation
+		elapsed time.Duration
+	)
+	emit := func() {
+		if !start.IsValid() || !group.ok {
+			return
+		}
+		e := recordEnv(start, "", group.loc)
+		e["ip"] = rangeString(start, prev)
+		p.enrich(e, func() { p.outputRecord(e, elapsed) })
+	}
+
+	for addr := first; ; addr = addr.Next() {
+		if p.unique != nil {
+			p.unique[addr] = struct{}{}
+		}
+
+		lookupStart := time.Now()
+		loc, ok, err := p.locate(addr)
+		if err != nil {
+			p.lookupErrors++
+			fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", addr, err)
+			emit()
+			start = netip.Addr{}
+		} else if c := (cachedLocation{loc, ok}); start.IsValid() && c == group {
+			elapsed += time.Since(lookupStart)
+			prev = addr
+		} else {
+			emit()
+			start, prev, group, elapsed = addr, addr, c, time.Since(lookupStart)
+		}
+
+		if addr == last {
+			emit()
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Defaults for -rdns.
+const (
+	defaultRDNSTimeout     = 2 * time.Second
+	defaultRDNSConcurrency = 16
+)
+
+// rdnsEnricher adds the hostname of each IP from a reverse DNS (PTR) lookup.
+// The hostname is empty if the IP has no PTR record or the lookup fails or
+// times out, so slow DNS does not stop the output.
+type rdnsEnricher struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+	sem      chan struct{} // limits the lookups in progress
+}
+
+// newRDNSEnricher returns an rdnsEnricher that waits at most timeout for
+// each lookup with at most concurrency lookups at once.
+func newRDNSEnricher(timeout time.Duration, concurrency int) *rdnsEnricher {
+	return &rdnsEnricher{
+		resolver: net.DefaultResolver,
+		timeout:  timeout,
+		sem:      make(chan struct{}, concurrency),
+	}
+}
+
+func (x *rdnsEnricher) addedColumns() []string {
+	return []string{"hostname"}
+}
+
+func (x *rdnsEnricher) enrich(e env) error {
+	e["hostname"] = ""
+
+	addr, err := netip.ParseAddr(formatValue(e["ip"]))
+	if err != nil {
+		return nil // changed by an earlier enricher
+	}
+
+	x.sem <- struct{}{}
+	defer func() { <-x.sem }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), x.timeout)
+	defer cancel()
+	names, err := x.resolver.LookupAddr(ctx, addr.Unmap().String())
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return nil // not found, timed out, or the server failed
+	}
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		e["hostname"] = strings.TrimSuffix(names[0], ".")
+	}
+	return nil
+}
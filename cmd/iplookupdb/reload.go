@@ -0,0 +1,164 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// defaultReloadInterval is the default interval between checks for a new
+// database file in long-running modes.
+const defaultReloadInterval = time.Minute
+
+// geoDB is a database used for lookups, of any of the types of -type. It is
+// a *geoip2.Reader or a *reloadingDB.
+type geoDB interface {
+	City(ip net.IP) (*geoip2.City, error)
+	Country(ip net.IP) (*geoip2.Country, error)
+	ASN(ip net.IP) (*geoip2.ASN, error)
+	AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error)
+	ISP(ip net.IP) (*geoip2.ISP, error)
+	Domain(ip net.IP) (*geoip2.Domain, error)
+	Metadata() maxminddb.Metadata
+}
+
+// reloadingDB is a geoDB that reopens the database file when it is
+// replaced, such as weekly by the MaxMind updater, or when a reload is
+// requested with SIGHUP. The new database is swapped in once lookups in
+// progress using the old one finish, so no lookups are dropped.
+type reloadingDB struct {
+	name string
+	lang string
+	v    verbose
+
+	mu         sync.RWMutex
+	db         *geoip2.Reader
+	info       os.FileInfo // of the file when opened
+	generation int         // incremented by each reload
+}
+
+// newReloadingDB returns a reloadingDB for db, which was opened from name,
+// that checks for a new file every interval, unless it is zero, and reloads
+// when a value is received from reload. The lang is checked against each
+// new database.
+func newReloadingDB(name string, db *geoip2.Reader, lang string, v verbose,
+	interval time.Duration, reload <-chan os.Signal,
+) *reloadingDB {
+	r := &reloadingDB{name: name, lang: lang, v: v, db: db}
+	r.info, _ = os.Stat(name)
+	go r.watch(interval, reload)
+	return r
+}
+
+func (r *reloadingDB) City(ip net.IP) (*geoip2.City, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db.City(ip)
+}
+
+func (r *reloadingDB) Country(ip net.IP) (*geoip2.Country, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db.Country(ip)
+}
+
+func (r *reloadingDB) ASN(ip net.IP) (*geoip2.ASN, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db.ASN(ip)
+}
+
+func (r *reloadingDB) AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db.AnonymousIP(ip)
+}
+
+func (r *reloadingDB) ISP(ip net.IP) (*geoip2.ISP, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db.ISP(ip)
+}
+
+func (r *reloadingDB) Domain(ip net.IP) (*geoip2.Domain, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db.Domain(ip)
+}
+
+func (r *reloadingDB) Metadata() maxminddb.Metadata {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db.Metadata()
+}
+
+// watch reloads the database when its file changes, checking every
+// interval, or when a value is received from reload. Errors are reported
+// and the current database is kept.
+func (r *reloadingDB) watch(interval time.Duration, reload <-chan os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+
+	for {
+		select {
+		case <-reload:
+		case <-tick:
+			info, err := os.Stat(r.name)
+			if err != nil || r.info == nil || os.SameFile(info, r.info) &&
+				info.ModTime().Equal(r.info.ModTime()) && info.Size() == r.info.Size() {
+				continue
+			}
+		}
+
+		if err := r.reload(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to reload database: %v\n", err)
+		}
+	}
+}
+
+// reload opens the database file and swaps it in, then closes the old one.
+func (r *reloadingDB) reload() error {
+	info, err := os.Stat(r.name)
+	if err != nil {
+		return err
+	}
+	db, err := geoip2.Open(r.name)
+	if err != nil {
+		return err
+	}
+	if err := validateLang(db, r.lang); err != nil {
+		db.Close()
+		return err
+	}
+
+	r.mu.Lock()
+	old := r.db
+	r.db, r.info = db, info
+	r.generation++
+	r.mu.Unlock()
+
+	r.v.metadata(r.name, db)
+	return old.Close()
+}
+
+// dbGeneration returns the number of times db has been reloaded, which is
+// always zero if it does not reload.
+func dbGeneration(db geoDB) int {
+	r, ok := db.(*reloadingDB)
+	if !ok {
+		return 0
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.generation
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import "net/netip"
+
+// Scopes of IPs, output as the scope field. IPs of a scope other than
+// public are not in the GeoIP databases and are handled by the -private
+// policy.
+const (
+	scopePublic        = "public"
+	scopePrivate       = "private"       // RFC 1918 and unique local addresses
+	scopeLoopback      = "loopback"      // 127.0.0.0/8 and ::1
+	scopeLinkLocal     = "link-local"    // 169.254.0.0/16 and fe80::/10
+	scopeCGNAT         = "cgnat"         // shared address space, 100.64.0.0/10
+	scopeMulticast     = "multicast"     // 224.0.0.0/4 and ff00::/8
+	scopeDocumentation = "documentation" // example addresses, such as 192.0.2.0/24
+	scopeUnspecified   = "unspecified"   // 0.0.0.0 and ::
+)
+
+// Prefixes of the scopes not reported by the methods of netip.Addr.
+var (
+	cgnatPrefix           = netip.MustParsePrefix("100.64.0.0/10")
+	documentationPrefixes = []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),    // TEST-NET-1, RFC 5737
+		netip.MustParsePrefix("198.51.100.0/24"), // TEST-NET-2, RFC 5737
+		netip.MustParsePrefix("203.0.113.0/24"),  // TEST-NET-3, RFC 5737
+		netip.MustParsePrefix("2001:db8::/32"),   // RFC 3849
+		netip.MustParsePrefix("3fff::/20"),       // RFC 9637
+	}
+)
+
+// ipScope returns the scope of addr, which must not be IPv4-mapped.
+func ipScope(addr netip.Addr) string {
+	switch {
+	case addr.IsUnspecified():
+		return scopeUnspecified
+	case addr.IsLoopback():
+		return scopeLoopback
+	case addr.IsPrivate():
+		return scopePrivate
+	case cgnatPrefix.Contains(addr):
+		return scopeCGNAT
+	case addr.IsLinkLocalUnicast():
+		return scopeLinkLocal
+	case addr.IsMulticast():
+		return scopeMulticast
+	}
+	for _, prefix := range documentationPrefixes {
+		if prefix.Contains(addr) {
+			return scopeDocumentation
+		}
+	}
+	return scopePublic
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrivateLocationLabel(t *testing.T) {
+	tests := []struct {
+		ip    string
+		label string // -private-label
+		want  string
+	}{
+		{"10.0.0.1", "", scopePrivate},
+		{"127.0.0.1", "", scopeLoopback},
+		{"100.64.0.1", "", scopeCGNAT},
+		{"2001:db8::1", "", scopeDocumentation},
+		{"192.168.1.1", "internal", "internal"},
+	}
+
+	for _, tt := range tests {
+		p := &processor{cfg: config{privateLabel: tt.label}}
+		addr := netip.MustParseAddr(tt.ip)
+		loc, ok, err := p.lookupLocation(addr)
+		if err != nil || !ok {
+			t.Errorf("lookupLocation(%s) = %v, %v, want ok", tt.ip, ok, err)
+			continue
+		}
+		e := recordEnv(addr, "", loc)
+
+		// the label is also the country_iso, so that -summary country,
+		// -annotate, and -include-country see it
+		if got := e["country_iso"]; got != tt.want {
+			t.Errorf("country_iso of %s = %v, want %s", tt.ip, got, tt.want)
+		}
+		want := " [" + tt.want + "/" + tt.want + "/" + tt.want + "]"
+		if got := annotation(defaultAnnotateFormat, e); got != want {
+			t.Errorf("annotation of %s = %q, want %q", tt.ip, got, want)
+		}
+
+		include := parseListFilters([]listFilter{{"country_iso", tt.want, false}}, false)
+		if v, err := include.eval(e); err != nil || v != true {
+			t.Errorf("-include-country %s of %s = %v, %v, want true", tt.want, tt.ip, v, err)
+		}
+	}
+}
+
+func TestSummaryCountryByScope(t *testing.T) {
+	p := &processor{cfg: config{summary: "country"}, rowFor: map[string]int{}}
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "127.0.0.1"} {
+		addr := netip.MustParseAddr(ip)
+		loc, _, err := p.lookupLocation(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.summarize(recordEnv(addr, "", loc))
+	}
+	p.summarize(env{"country_iso": ""}) // a public IP not in the db
+	p.sortSummary()
+
+	want := []struct {
+		iso   string
+		count int
+	}{{scopePrivate, 2}, {scopeLoopback, 1}, {"unknown", 1}}
+	if len(p.rows) != len(want) {
+		t.Fatalf("got %d groups, want %d", len(p.rows), len(want))
+	}
+	for n, w := range want {
+		if got := p.rows[n]["country_iso"]; got != w.iso || p.counts[n] != w.count {
+			t.Errorf("group %d = %v,%d, want %s,%d", n, got, p.counts[n], w.iso, w.count)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// script is a Starlark script used to modify, enrich, or drop records.
+//
+// The script must define a function process(record), which is called with
+// each record as a dict of its fields. The function may change or add keys in
+// the dict. It returns the record to output it or None to drop it.
+//
+// The script may define a list of strings named columns with the names of
+// keys added by process that are output as extra columns.
+type script struct {
+	thread  *starlark.Thread
+	process starlark.Callable
+	columns []string
+}
+
+// loadScript loads the Starlark script from the file name. The names of the
+// existing record fields are used to validate the extra columns.
+func loadScript(name string, fields []string) (*script, error) {
+	thread := &starlark.Thread{
+		Name:  name,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Fprintln(os.Stderr, msg) },
+	}
+
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	process, ok := globals["process"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s: must define a process(record) function", name)
+	}
+
+	s := &script{thread: thread, process: process}
+
+	if v, found := globals["columns"]; found {
+		list, ok := v.(*starlark.List)
+		if !ok {
+			return nil, fmt.Errorf("%s: columns must be a list of strings", name)
+		}
+		for i := 0; i < list.Len(); i++ {
+			col, ok := starlark.AsString(list.Index(i))
+			if !ok || col == "" {
+				return nil, fmt.Errorf("%s: columns must be a list of strings", name)
+			}
+			if slices.Contains(fields, col) {
+				return nil, fmt.Errorf("%s: column %q is already a field", name, col)
+			}
+			s.columns = append(s.columns, col)
+		}
+	}
+
+	return s, nil
+}
+
+// run calls the process function of the script with the record e, updating e
+// with any changes made by the script. If the script drops the record, keep
+// is false.
+func (s *script) run(e env) (keep bool, err error) {
+	record := starlark.NewDict(len(e))
+	for k, v := range e {
+		if err := record.SetKey(starlark.String(k), toStarlark(v)); err != nil {
+			return false, err
+		}
+	}
+
+	result, err := starlark.Call(s.thread, s.process, starlark.Tuple{record}, nil)
+	if err != nil {
+		return false, err
+	}
+	if result == starlark.None {
+		return false, nil
+	}
+
+	dict, ok := result.(*starlark.Dict)
+	if !ok {
+		return false, errors.New("process must return a dict or None")
+	}
+
+	clear(e)
+	for _, item := range dict.Items() {
+		k, ok := starlark.AsString(item[0])
+		if !ok {
+			return false, fmt.Errorf("record key %v is not a string", item[0])
+		}
+		e[k] = fromStarlark(item[1])
+	}
+	return true, nil
+}
+
+// toStarlark returns the Starlark value of the record field value v.
+func toStarlark(v any) starlark.Value {
+	switch v := v.(type) {
+	case string:
+		return starlark.String(v)
+	case float64:
+		return starlark.Float(v)
+	case bool:
+		return starlark.Bool(v)
+	}
+	return starlark.None
+}
+
+// fromStarlark returns the record field value of the Starlark value v.
+func fromStarlark(v starlark.Value) any {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.String:
+		return string(v)
+	case starlark.Bool:
+		return bool(v)
+	case starlark.Float:
+		return float64(v)
+	case starlark.Int:
+		f, _ := starlark.AsFloat(v)
+		return f
+	}
+	return v.String()
+}
+
+// runScript runs the script, if any, on the record e and reports whether the
+// record should be output. Script errors are reported and the record is
+// dropped.
+func (p *processor) runScript(e env) bool {
+	if p.cfg.script == nil {
+		return true
+	}
+
+	ip := e["ip"]
+	keep, err := p.cfg.script.run(e)
+	if err != nil {
+		var evalErr *starlark.EvalError
+		if errors.As(err, &evalErr) {
+			err = errors.New(evalErr.Backtrace())
+		}
+		fmt.Fprintf(os.Stderr, "Error in script for IP %v: %v\n", ip, err)
+		return false
+	}
+	return keep
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// selftestCase is an IP with a well-known country.
+type selftestCase struct {
+	ip      string
+	country string // expected ISO country code
+	about   string
+}
+
+// selftestCases are well-known public IPs and the addresses documented in
+// the MaxMind test databases, which are geolocated the same in production
+// databases.
+var selftestCases = []selftestCase{
+	{"8.8.8.8", "US", "Google Public DNS"},
+	{"208.67.222.222", "US", "OpenDNS"},
+	{"81.2.69.142", "GB", "MaxMind test address"},
+	{"89.160.20.112", "SE", "MaxMind test address"},
+	{"175.16.199.0", "CN", "MaxMind test address"},
+	{"2001:218::", "JP", "MaxMind test address"},
+}
+
+// runSelftest runs the selftest subcommand, which looks up well-known IPs in
+// the database and verifies the results are plausible, for post-install
+// validation in automation.
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	dbName := fs.String("db", "GeoLite2-City.mmdb", "Path to the GeoLite2 City database")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	db, err := geoip2.Open(*dbName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return exitDB
+	}
+	defer db.Close()
+
+	if failed := selftest(os.Stdout, db); failed > 0 {
+		return exitLookupErrors
+	}
+	return exitOK
+}
+
+// selftest looks up each of the selftestCases in db, writes the results to
+// w, and returns the number of failures.
+func selftest(w io.Writer, db *geoip2.Reader) int {
+	failed := 0
+	for _, c := range selftestCases {
+		result := "PASS"
+		got, err := selftestCountry(db, c.ip)
+		if err != nil {
+			got = err.Error()
+		}
+		if got != c.country {
+			result = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "%s %s (%s): want %s, got %s\n", result, c.ip, c.about, c.country, got)
+	}
+
+	// private IPs are labeled with their scope without the database
+	result := "PASS"
+	got, err := selftestPrivate(db, "10.0.0.1")
+	if err != nil {
+		got = err.Error()
+	}
+	if got != scopePrivate {
+		result = "FAIL"
+		failed++
+	}
+	fmt.Fprintf(w, "%s 10.0.0.1 (private address): want %s, got %s\n", result, scopePrivate, got)
+
+	fmt.Fprintf(w, "%d of %d checks failed\n", failed, len(selftestCases)+1)
+	return failed
+}
+
+// selftestPrivate returns the label of ip given by the default private
+// policy, or why there is none. The scope output for ip must match the label.
+func selftestPrivate(db *geoip2.Reader, ip string) (string, error) {
+	addr := netip.MustParseAddr(ip)
+	p := newProcessor(io.Discard, config{private: privateLabel}, db, nil)
+	loc, ok, err := p.locate(addr)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "skipped", nil
+	}
+	if scope := recordEnv(addr, "", loc)["scope"]; scope != loc.country {
+		return fmt.Sprintf("%s with scope %s", loc.country, scope), nil
+	}
+	return loc.country, nil
+}
+
+// selftestCountry returns the ISO country code of ip in db.
+func selftestCountry(db *geoip2.Reader, ip string) (string, error) {
+	record, err := db.City(net.ParseIP(ip))
+	if err != nil {
+		return "", err
+	}
+	if record.Country.IsoCode == "" {
+		return "no record", nil
+	}
+	return record.Country.IsoCode, nil
+}
@@ -0,0 +1,247 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Limits of the lookup server.
+const (
+	serveMaxBatch   = 10000            // maximum IPs in a batch lookup
+	serveMaxBody    = 1 << 20          // maximum size of a batch request
+	serveShutdown   = 10 * time.Second // time allowed for requests to finish
+	serveReadHeader = 10 * time.Second // time allowed to read request headers
+)
+
+// server answers lookup requests over HTTP and gRPC using a shared db.
+type server struct {
+	cfg     config
+	db      geoDB
+	sites   siteDB
+	metrics *metrics // nil unless the metrics exporter is enabled
+	counts  serveCounts
+
+	// serializes lookups if there is a script, which is not safe for
+	// concurrent use
+	mu sync.Mutex
+}
+
+// serveCounts are the totals of the lookups by the server, served at
+// /metrics.
+type serveCounts struct {
+	requests, ips, written, invalid, lookupErrors atomic.Int64
+}
+
+// add adds a request for ips and the counts of its processor p.
+func (c *serveCounts) add(ips []string, p *processor) {
+	c.requests.Add(1)
+	c.ips.Add(int64(len(ips)))
+	c.written.Add(int64(p.written))
+	c.invalid.Add(int64(p.invalid))
+	c.lookupErrors.Add(int64(p.lookupErrors))
+}
+
+// write writes the counts to w in the Prometheus text format.
+func (c *serveCounts) write(w io.Writer) {
+	for _, m := range []struct {
+		name, help string
+		v          *atomic.Int64
+	}{
+		{"iplookupdb_requests_total", "Lookup requests served.", &c.requests},
+		{"iplookupdb_lookups_total", "IPs received in lookup requests.", &c.ips},
+		{"iplookupdb_written_total", "Records returned.", &c.written},
+		{"iplookupdb_invalid_ips_total", "Invalid IPs received.", &c.invalid},
+		{"iplookupdb_lookup_errors_total", "Failed lookups.", &c.lookupErrors},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", m.name, m.help, m.name, m.name, m.v.Load())
+	}
+}
+
+// serve answers lookup requests over HTTP on the -serve address and over
+// gRPC on the -grpc address, if given, until interrupted or terminated, then
+// waits for requests in progress to finish.
+func serve(cfg config, db geoDB, sites siteDB) error {
+	s := &server{cfg: cfg, db: db, sites: sites}
+	if cfg.metricsAddr != "" {
+		s.metrics = newMetrics(cfg.metricsLabels)
+		if err := serveMetrics(cfg.metricsAddr, s.metrics); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 2)
+
+	var srv *http.Server
+	if cfg.serve != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /lookup/{ip}", s.lookupOne)
+		mux.HandleFunc("POST /lookup", s.lookupBatch)
+		mux.HandleFunc("GET /metrics", s.serveMetrics)
+		mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("OK\n"))
+		})
+
+		srv = &http.Server{Addr: cfg.serve, Handler: mux, ReadHeaderTimeout: serveReadHeader}
+		go func() { errc <- srv.ListenAndServe() }()
+		cfg.verbose.printf("serving lookups on %s", cfg.serve)
+	}
+
+	var gs *grpc.Server
+	if cfg.grpc != "" {
+		lis, err := net.Listen("tcp", cfg.grpc)
+		if err != nil {
+			if srv != nil {
+				srv.Close()
+			}
+			return err
+		}
+		gs = newGRPCServer(s)
+		go func() { errc <- gs.Serve(lis) }()
+		cfg.verbose.printf("serving gRPC lookups on %s", cfg.grpc)
+	}
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+	}
+
+	cfg.verbose.printf("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), serveShutdown)
+	defer cancel()
+	if gs != nil {
+		stopGRPC(ctx, gs)
+	}
+	if srv != nil {
+		if shutdownErr := srv.Shutdown(ctx); err == nil {
+			err = shutdownErr
+		}
+	}
+	return err
+}
+
+// lookup writes the records for ips to w in the format, using the same
+// fields, filters, and enrichers as the command line, and returns the
+// processor used for its counts. Invalid IPs are skipped.
+func (s *server) lookup(w io.Writer, format string, ips []string) *processor {
+	if s.cfg.script != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+
+	cfg := s.cfg
+	cfg.format = format
+	cfg.skipInvalid = true
+
+	p := newProcessor(w, cfg, s.db, s.sites)
+	p.metrics = s.metrics
+	for _, ip := range ips {
+		p.processIP(ip)
+	}
+	p.finish()
+	s.counts.add(ips, p)
+	return p
+}
+
+// records returns the records for ips, using the same fields, filters, and
+// enrichers as the command line, and the processor used for its counts.
+// Invalid IPs are skipped.
+func (s *server) records(ips []string) ([]env, *processor) {
+	if s.cfg.script != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+
+	cfg := s.cfg
+	cfg.skipInvalid = true
+
+	var records []env
+	p := newProcessor(io.Discard, cfg, s.db, s.sites)
+	p.rw = &collectWriter{writeAll: func(r []env) error {
+		records = r
+		return nil
+	}}
+	p.metrics = s.metrics
+	for _, ip := range ips {
+		p.processIP(ip)
+	}
+	p.finish()
+	s.counts.add(ips, p)
+	return records, p
+}
+
+// lookupOne handles GET /lookup/{ip}, responding with the record for the IP
+// as a JSON object.
+func (s *server) lookupOne(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	p := s.lookup(&b, formatNDJSON, []string{r.PathValue("ip")})
+
+	switch {
+	case p.invalid > 0:
+		serveError(w, http.StatusBadRequest, "invalid IP")
+	case p.lookupErrors > 0:
+		serveError(w, http.StatusInternalServerError, "lookup failed")
+	case b.Len() == 0:
+		serveError(w, http.StatusNotFound, "no record")
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b.Bytes())
+	}
+}
+
+// lookupBatch handles POST /lookup, which has a JSON array of IPs as the
+// body, responding with a JSON array of the records for the valid IPs.
+func (s *server) lookupBatch(w http.ResponseWriter, r *http.Request) {
+	var ips []string
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, serveMaxBody)).Decode(&ips)
+	if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) || len(ips) > serveMaxBatch {
+		serveError(w, http.StatusRequestEntityTooLarge, "too many IPs")
+		return
+	}
+	if err != nil {
+		serveError(w, http.StatusBadRequest, "body must be a JSON array of IPs")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	s.lookup(w, formatJSON, ips)
+}
+
+// serveMetrics handles GET /metrics, responding with the lookup counts and
+// the counts of the metrics exporter, if enabled, in the Prometheus text
+// format.
+func (s *server) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.counts.write(w)
+	if s.metrics != nil {
+		s.metrics.write(w)
+	}
+}
+
+// serveError responds with the HTTP status code and a JSON object with the
+// error message.
+func serveError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,235 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Formats of the sets subcommand.
+const (
+	setsIPSet    = "ipset"    // ipset restore commands
+	setsNFTables = "nftables" // an nftables table for nft -f
+)
+
+// countryPrefixes returns the IPv4 and IPv6 prefixes in the MMDB name that
+// are geolocated to one of the countries, by ISO code.
+func countryPrefixes(name string, countries []string) (v4, v6 []netip.Prefix, err error) {
+	db, err := maxminddb.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	var record struct {
+		Country struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+
+	networks := db.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		record.Country.IsoCode = ""
+		network, err := networks.Network(&record)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !slices.Contains(countries, record.Country.IsoCode) {
+			continue
+		}
+
+		addr, _ := netip.AddrFromSlice(network.IP)
+		ones, _ := network.Mask.Size()
+		prefix := netip.PrefixFrom(addr.Unmap(), ones)
+		if prefix.Addr().Is4() {
+			v4 = append(v4, prefix)
+		} else {
+			v6 = append(v6, prefix)
+		}
+	}
+	return v4, v6, networks.Err()
+}
+
+// csvCountryPrefixes returns the IPv4 and IPv6 prefixes in the GeoLite2 CSV
+// blocks files that are geolocated to one of the countries, by ISO code,
+// using the country codes in the locations file.
+func csvCountryPrefixes(blocks []string, locations string, countries []string) (v4, v6 []netip.Prefix, err error) {
+	// geoname IDs of the countries
+	ids := make(map[string]bool)
+	err = readCSVFile(locations, func(record map[string]string) error {
+		if slices.Contains(countries, record["country_iso_code"]) {
+			ids[record["geoname_id"]] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, name := range blocks {
+		err = readCSVFile(name, func(record map[string]string) error {
+			if !ids[record["geoname_id"]] {
+				return nil
+			}
+			prefix, err := netip.ParsePrefix(record["network"])
+			if err != nil {
+				return err
+			}
+			if prefix.Addr().Is4() {
+				v4 = append(v4, prefix)
+			} else {
+				v6 = append(v6, prefix)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return v4, v6, nil
+}
+
+// readCSVFile calls fn with each record of the CSV file name, which has a
+// header, as a map from the column name to the value.
+func readCSVFile(name string, fn func(map[string]string) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for {
+		fields, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		record := make(map[string]string, len(header))
+		for n, column := range header {
+			record[column] = fields[n]
+		}
+		if err := fn(record); err != nil {
+			line, _ := r.FieldPos(0)
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+	}
+}
+
+// writeIPSet writes ipset restore commands creating the sets name-v4 and
+// name-v6 with the prefixes.
+func writeIPSet(w io.Writer, name string, v4, v6 []netip.Prefix) {
+	for _, set := range []struct {
+		family   string
+		suffix   string
+		prefixes []netip.Prefix
+	}{{"inet", "v4", v4}, {"inet6", "v6", v6}} {
+		setName := name + "-" + set.suffix
+		fmt.Fprintf(w, "create %s hash:net family %s maxelem %d -exist\n",
+			setName, set.family, max(65536, len(set.prefixes)))
+		fmt.Fprintf(w, "flush %s\n", setName)
+		for _, prefix := range set.prefixes {
+			fmt.Fprintf(w, "add %s %s -exist\n", setName, prefix)
+		}
+	}
+}
+
+// writeNFTables writes an nftables table name with the sets name_v4 and
+// name_v6 with the prefixes.
+func writeNFTables(w io.Writer, name string, v4, v6 []netip.Prefix) {
+	fmt.Fprintf(w, "table inet %s {\n", name)
+	for _, set := range []struct {
+		typ      string
+		suffix   string
+		prefixes []netip.Prefix
+	}{{"ipv4_addr", "v4", v4}, {"ipv6_addr", "v6", v6}} {
+		fmt.Fprintf(w, "\tset %s_%s {\n", name, set.suffix)
+		fmt.Fprintf(w, "\t\ttype %s\n", set.typ)
+		fmt.Fprintf(w, "\t\tflags interval\n")
+		if len(set.prefixes) > 0 {
+			fmt.Fprintf(w, "\t\tauto-merge\n")
+			fmt.Fprintf(w, "\t\telements = {\n")
+			for n, prefix := range set.prefixes {
+				sep := ","
+				if n == len(set.prefixes)-1 {
+					sep = ""
+				}
+				fmt.Fprintf(w, "\t\t\t%s%s\n", prefix, sep)
+			}
+			fmt.Fprintf(w, "\t\t}\n")
+		}
+		fmt.Fprintf(w, "\t}\n")
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+// runSets runs the sets subcommand, which writes ipset or nftables set
+// definitions of all prefixes geolocated to the countries given as ISO
+// codes, from a MMDB or the GeoLite2 Country or City CSV files.
+func runSets(args []string) int {
+	fs := flag.NewFlagSet("sets", flag.ContinueOnError)
+	dbName := fs.String("db", "GeoLite2-City.mmdb", "Path to the GeoLite2 City or Country database")
+	var blocks stringsFlag
+	fs.Var(&blocks, "blocks", "GeoLite2 CSV blocks file to read instead of -db. May be repeated for IPv4 and IPv6.")
+	locations := fs.String("locations", "", "GeoLite2 CSV locations file used with -blocks.")
+	format := fs.String("format", setsNFTables, "Format of the sets: ipset or nftables.")
+	name := fs.String("name", "geo", "Name of the sets, or the nftables table.")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	var countries []string
+	for _, arg := range fs.Args() {
+		for _, country := range strings.Split(arg, ",") {
+			countries = append(countries, strings.ToUpper(strings.TrimSpace(country)))
+		}
+	}
+	switch {
+	case len(countries) == 0:
+		fmt.Fprintln(os.Stderr, "usage: iplookupdb sets [flags] country ...")
+		return exitUsage
+	case *format != setsIPSet && *format != setsNFTables:
+		fmt.Fprintf(os.Stderr, "Invalid option: unknown -format %q\n", *format)
+		return exitUsage
+	case len(blocks) > 0 && *locations == "":
+		fmt.Fprintln(os.Stderr, "Invalid option: -blocks requires -locations")
+		return exitUsage
+	}
+
+	var v4, v6 []netip.Prefix
+	var err error
+	if len(blocks) > 0 {
+		v4, v6, err = csvCountryPrefixes(blocks, *locations, countries)
+	} else {
+		v4, v6, err = countryPrefixes(*dbName, countries)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read database: %v\n", err)
+		return exitDB
+	}
+
+	if *format == setsIPSet {
+		writeIPSet(os.Stdout, *name, v4, v6)
+	} else {
+		writeNFTables(os.Stdout, *name, v4, v6)
+	}
+	return exitOK
+}
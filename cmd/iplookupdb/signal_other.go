@@ -0,0 +1,20 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+//go:build !unix
+
+package main
+
+import "os"
+
+// progressSignal returns nil since SIGUSR1 is not available, so a progress
+// dump is never requested.
+func progressSignal() <-chan os.Signal {
+	return nil
+}
+
+// reloadSignal returns nil since SIGHUP is not available, so the database
+// is only reloaded when its file changes.
+func reloadSignal() <-chan os.Signal {
+	return nil
+}
@@ -0,0 +1,28 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// progressSignal returns a channel that receives a value when a progress
+// dump is requested by sending SIGUSR1 to the process.
+func progressSignal() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	return c
+}
+
+// reloadSignal returns a channel that receives a value when a database
+// reload is requested by sending SIGHUP to the process.
+func reloadSignal() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	return c
+}
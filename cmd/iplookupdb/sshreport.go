@@ -0,0 +1,220 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// sshFailure matches sshd log lines for failed logins, with the kind of
+// failure in the first group and the IP in the second.
+var sshFailure = regexp.MustCompile(`sshd\[\d+\]: (Failed \S+ for (?:invalid user )?|Invalid user )\S* from (\S+)`)
+
+// sshAttacker is the failed login attempts from an IP.
+type sshAttacker struct {
+	addr         netip.Addr
+	attempts     int
+	invalidUsers int // attempts for users that do not exist
+}
+
+// sshReport is the failed login attempts found in an sshd log.
+type sshReport struct {
+	attackers map[netip.Addr]*sshAttacker
+}
+
+// add adds the failed login attempt, if any, in line.
+func (r *sshReport) add(line string) {
+	m := sshFailure.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+
+	// a failed login for an invalid user follows the Invalid user line for
+	// the same attempt
+	kind := m[1]
+	if strings.HasSuffix(kind, "invalid user ") {
+		return
+	}
+
+	addr, err := netip.ParseAddr(m[2])
+	if err != nil {
+		return
+	}
+	addr = addr.Unmap()
+
+	a, found := r.attackers[addr]
+	if !found {
+		a = &sshAttacker{addr: addr}
+		r.attackers[addr] = a
+	}
+	a.attempts++
+	if kind == "Invalid user " {
+		a.invalidUsers++
+	}
+}
+
+// read adds the failed login attempts in the sshd log r.
+func (r *sshReport) read(rd io.Reader) error {
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		r.add(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// sorted returns the attackers with the most attempts first.
+func (r *sshReport) sorted() []*sshAttacker {
+	var attackers []*sshAttacker
+	for _, a := range r.attackers {
+		attackers = append(attackers, a)
+	}
+	slices.SortFunc(attackers, func(a, b *sshAttacker) int {
+		if c := cmp.Compare(b.attempts, a.attempts); c != 0 {
+			return c
+		}
+		return a.addr.Compare(b.addr)
+	})
+	return attackers
+}
+
+// runSSHReport runs the sshreport subcommand, which reads sshd auth.log
+// files and writes a CSV report of the IPs with failed logins, their
+// location, and their attempts, with the most attempts first. With -by
+// country, the attempts are summarized by country instead.
+func runSSHReport(args []string) int {
+	fs := flag.NewFlagSet("sshreport", flag.ContinueOnError)
+	dbName := fs.String("db", "GeoLite2-City.mmdb", "Path to the GeoLite2 City database")
+	lang := fs.String("lang", "en", "Language for GeoIP lookup results.")
+	by := fs.String("by", "ip", "Summarize attempts by ip or country.")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *by != "ip" && *by != "country" {
+		fmt.Fprintf(os.Stderr, "Invalid option: -by must be ip or country, got %q\n", *by)
+		return exitUsage
+	}
+
+	db, err := geoip2.Open(*dbName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return exitDB
+	}
+	defer db.Close()
+
+	report := &sshReport{attackers: make(map[netip.Addr]*sshAttacker)}
+	if fs.NArg() == 0 {
+		err = report.read(os.Stdin)
+	}
+	for _, name := range fs.Args() {
+		if err = readFile(name, report.read); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read input: %v\n", err)
+		return exitInput
+	}
+
+	cfg := config{lang: *lang, private: privateLabel}
+	p := newProcessor(io.Discard, cfg, db, nil)
+
+	w := csv.NewWriter(os.Stdout)
+	if *by == "country" {
+		writeSSHCountries(w, p, report.sorted())
+	} else {
+		writeSSHAttackers(w, p, report.sorted())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write output: %v\n", err)
+		return exitOutput
+	}
+	return exitOK
+}
+
+// readFile calls read with the contents of the file name.
+func readFile(name string, read func(io.Reader) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return read(f)
+}
+
+// sshLocation returns the location of the attacker using p, with unknown
+// for values not found.
+func sshLocation(p *processor, a *sshAttacker) location {
+	loc, _, err := p.locate(a.addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", a.addr, err)
+	}
+	for _, s := range []*string{&loc.city, &loc.subdivision, &loc.country, &loc.countryISO} {
+		if *s == "" {
+			*s = "unknown"
+		}
+	}
+	return loc
+}
+
+// writeSSHAttackers writes a row for each attacker.
+func writeSSHAttackers(w *csv.Writer, p *processor, attackers []*sshAttacker) {
+	w.Write([]string{"ip", "city", "subdivision", "country", "attempts", "invalid_users"})
+	for _, a := range attackers {
+		loc := sshLocation(p, a)
+		w.Write([]string{
+			a.addr.String(), loc.city, loc.subdivision, loc.country,
+			strconv.Itoa(a.attempts), strconv.Itoa(a.invalidUsers),
+		})
+	}
+}
+
+// writeSSHCountries writes a row for each country of the attackers with the
+// number of IPs and attempts, with the most attempts first.
+func writeSSHCountries(w *csv.Writer, p *processor, attackers []*sshAttacker) {
+	type summary struct {
+		country, iso                string
+		ips, attempts, invalidUsers int
+	}
+
+	var countries []*summary
+	byCountry := make(map[string]*summary)
+	for _, a := range attackers {
+		loc := sshLocation(p, a)
+		s, found := byCountry[loc.country]
+		if !found {
+			s = &summary{country: loc.country, iso: loc.countryISO}
+			byCountry[loc.country] = s
+			countries = append(countries, s)
+		}
+		s.ips++
+		s.attempts += a.attempts
+		s.invalidUsers += a.invalidUsers
+	}
+
+	slices.SortStableFunc(countries, func(a, b *summary) int {
+		return cmp.Compare(b.attempts, a.attempts)
+	})
+
+	w.Write([]string{"country", "country_iso", "ips", "attempts", "invalid_users"})
+	for _, s := range countries {
+		w.Write([]string{
+			s.country, s.iso,
+			strconv.Itoa(s.ips), strconv.Itoa(s.attempts), strconv.Itoa(s.invalidUsers),
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// runStats summarizes a run for pipeline monitoring.
+type runStats struct {
+	Records        int     `json:"records"`
+	Written        int     `json:"written"`
+	InvalidIPs     int     `json:"invalid_ips"`
+	LookupErrors   int     `json:"lookup_errors"`
+	BinaryRecords  int     `json:"binary_records"`
+	UniqueIPs      int     `json:"unique_ips"`
+	CacheHitRate   float64 `json:"cache_hit_rate"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	RecordsPerSec  float64 `json:"records_per_second"`
+}
+
+// stats returns the statistics for the run of p that started at start.
+func (p *processor) stats(start time.Time) runStats {
+	elapsed := time.Since(start).Seconds()
+
+	s := runStats{
+		Records:        p.records,
+		Written:        p.written,
+		InvalidIPs:     p.invalid,
+		LookupErrors:   p.lookupErrors,
+		BinaryRecords:  p.binary,
+		UniqueIPs:      len(p.unique),
+		ElapsedSeconds: elapsed,
+	}
+	if lookups := p.cacheHits + p.cacheMisses; lookups > 0 {
+		s.CacheHitRate = float64(p.cacheHits) / float64(lookups)
+	}
+	if elapsed > 0 {
+		s.RecordsPerSec = float64(p.records) / elapsed
+	}
+	return s
+}
+
+// writeStats writes s as JSON to the file name, or to stderr if name is empty.
+func writeStats(s runStats, name string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if name == "" {
+		_, err = os.Stderr.Write(b)
+		return err
+	}
+	return os.WriteFile(name, b, 0666)
+}
+
+// printProgress writes the current progress and rates of p to stderr,
+// labeled with label, such as progress, and the estimated time remaining if
+// the size of the input is known.
+func (p *processor) printProgress(label string) {
+	s := p.stats(p.start)
+	elapsed := time.Since(p.start)
+	fmt.Fprintf(os.Stderr,
+		"%s: %d records, %d written, %d invalid, %d errors in %s (%.0f records/sec)",
+		label, s.Records, s.Written, s.InvalidIPs, s.LookupErrors,
+		elapsed.Round(time.Second), s.RecordsPerSec)
+
+	if read := p.offset - p.startOffset; p.inputSize > 0 && read > 0 && p.offset < p.inputSize {
+		percent := 100 * float64(p.offset) / float64(p.inputSize)
+		eta := time.Duration(float64(elapsed) * float64(p.inputSize-p.offset) / float64(read))
+		fmt.Fprintf(os.Stderr, ", %.1f%%, ETA %s", percent, eta.Round(time.Second))
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// inputSize returns the size of the input r if it is a regular file, or 0
+// if its size is unknown, such as for a pipe.
+func inputSize(r io.Reader) int64 {
+	f, ok := r.(*os.File)
+	if !ok {
+		return 0
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stixNamespace is the namespace for deterministic STIX identifiers from the
+// STIX 2.1 specification.
+var stixNamespace = [16]byte{
+	0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c,
+	0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7,
+}
+
+// stixID returns the identifier of a STIX object of type typ using a UUIDv5
+// of name, so the same object always has the same identifier. For cyber
+// observables, name is the JSON of the ID contributing properties.
+func stixID(typ, name string) string {
+	h := sha1.New()
+	h.Write(stixNamespace[:])
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = u[6]&0x0f | 0x50 // version 5
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%s--%x-%x-%x-%x-%x", typ, u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// stixAddr is an ipv4-addr or ipv6-addr cyber observable.
+type stixAddr struct {
+	Type        string `json:"type"`
+	SpecVersion string `json:"spec_version"`
+	ID          string `json:"id"`
+	Value       string `json:"value"`
+}
+
+// stixLocation is a location domain object.
+type stixLocation struct {
+	Type               string `json:"type"`
+	SpecVersion        string `json:"spec_version"`
+	ID                 string `json:"id"`
+	Created            string `json:"created"`
+	Modified           string `json:"modified"`
+	Name               string `json:"name,omitempty"`
+	Country            string `json:"country"`
+	AdministrativeArea string `json:"administrative_area,omitempty"`
+	City               string `json:"city,omitempty"`
+}
+
+// stixRelationship is a relationship between two STIX objects.
+type stixRelationship struct {
+	Type             string `json:"type"`
+	SpecVersion      string `json:"spec_version"`
+	ID               string `json:"id"`
+	Created          string `json:"created"`
+	Modified         string `json:"modified"`
+	RelationshipType string `json:"relationship_type"`
+	SourceRef        string `json:"source_ref"`
+	TargetRef        string `json:"target_ref"`
+}
+
+// writeSTIX writes the records as a STIX 2.1 bundle with an ipv4-addr or
+// ipv6-addr observable for each IP, a location object for each location,
+// and located-at relationships between them. Objects are created at t.
+// Private IPs and IPs without a known country have no location.
+func writeSTIX(w io.Writer, records []env, t time.Time) error {
+	created := t.Format("2006-01-02T15:04:05.000Z")
+
+	objects := []any{}
+	seen := make(map[string]bool)
+	add := func(id string, object any) {
+		if !seen[id] {
+			seen[id] = true
+			objects = append(objects, object)
+		}
+	}
+
+	for _, e := range records {
+		ip := formatValue(e["ip"])
+		typ := "ipv4-addr"
+		if strings.Contains(ip, ":") {
+			typ = "ipv6-addr"
+		}
+		value, _ := json.Marshal(map[string]string{"value": ip})
+		addr := stixAddr{Type: typ, SpecVersion: "2.1", ID: stixID(typ, string(value)), Value: ip}
+		add(addr.ID, addr)
+
+		country := formatValue(e["country_iso"])
+		if country == "" || e["is_private"] == true {
+			continue
+		}
+
+		loc := stixLocation{
+			Type:               "location",
+			SpecVersion:        "2.1",
+			Created:            created,
+			Modified:           created,
+			Country:            country,
+			AdministrativeArea: formatValue(e["subdivision"]),
+			City:               formatValue(e["city"]),
+		}
+		var place []string
+		for _, s := range []string{loc.City, loc.AdministrativeArea, formatValue(e["country"])} {
+			if s != "" {
+				place = append(place, s)
+			}
+		}
+		loc.Name = strings.Join(place, ", ")
+		loc.ID = stixID("location", strings.Join([]string{loc.Country, loc.AdministrativeArea, loc.City}, "\x00"))
+		add(loc.ID, loc)
+
+		rel := stixRelationship{
+			Type:             "relationship",
+			SpecVersion:      "2.1",
+			ID:               stixID("relationship", addr.ID+"\x00"+loc.ID),
+			Created:          created,
+			Modified:         created,
+			RelationshipType: "located-at",
+			SourceRef:        addr.ID,
+			TargetRef:        loc.ID,
+		}
+		add(rel.ID, rel)
+	}
+
+	var ids []string
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	bundle := struct {
+		Type    string `json:"type"`
+		ID      string `json:"id"`
+		Objects []any  `json:"objects"`
+	}{"bundle", stixID("bundle", created+"\x00"+strings.Join(ids, "\x00")), objects}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bundle)
+}
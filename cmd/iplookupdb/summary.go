@@ -0,0 +1,60 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// summaryColumns are the columns of each -summary grouping, which are the
+// fields records are grouped by.
+var summaryColumns = map[string][]string{
+	"country": {"country_iso"},
+	"city":    {"city", "country_iso"},
+	"asn":     {"asn", "as_org"},
+}
+
+// summarize counts the record e in its group for -summary. The groups are
+// written by finish, most common first.
+func (p *processor) summarize(e env) {
+	columns := summaryColumns[p.cfg.summary]
+	group := make(env, len(columns))
+	key := make([]string, len(columns))
+	for n, name := range columns {
+		v := formatValue(e[name])
+		if v == "" {
+			v = "unknown"
+		}
+		group[name], key[n] = v, v
+	}
+	p.collapse(strings.Join(key, "\x00"), group)
+}
+
+// sortSummary sorts the -summary groups by count, most common first, and
+// then by their values for a stable order.
+func (p *processor) sortSummary() {
+	order := make([]int, len(p.rows))
+	for n := range order {
+		order[n] = n
+	}
+	columns := summaryColumns[p.cfg.summary]
+	slices.SortFunc(order, func(a, b int) int {
+		if p.counts[a] != p.counts[b] {
+			return p.counts[b] - p.counts[a]
+		}
+		for _, name := range columns {
+			if c := strings.Compare(p.rows[a][name].(string), p.rows[b][name].(string)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+
+	rows, counts := make([]env, len(order)), make([]int, len(order))
+	for n, i := range order {
+		rows[n], counts[n] = p.rows[i], p.counts[i]
+	}
+	p.rows, p.counts = rows, counts
+}
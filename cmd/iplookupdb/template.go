@@ -0,0 +1,82 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// templateInitialisms are the parts of field names written in upper case in
+// template names, such as IP for ip and CountryISO for country_iso.
+var templateInitialisms = map[string]bool{
+	"ip": true, "iso": true, "asn": true, "as": true, "vpn": true, "us": true, "db": true,
+}
+
+// templateName returns the name of the field in -template, such as
+// CountryISO for country_iso.
+func templateName(field string) string {
+	var sb strings.Builder
+	for _, part := range strings.Split(field, "_") {
+		if templateInitialisms[part] {
+			sb.WriteString(strings.ToUpper(part))
+		} else if part != "" {
+			sb.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return sb.String()
+}
+
+// templateData returns the data of the record e for a template, which has
+// each of the fields, and any other fields of e, by its template name.
+// Unknown values are empty.
+func templateData(fields []string, e env) map[string]any {
+	data := make(map[string]any, len(fields)+len(e))
+	for _, name := range fields {
+		data[templateName(name)] = ""
+	}
+	for name, v := range e {
+		if v == nil {
+			v = ""
+		}
+		data[templateName(name)] = v
+	}
+	return data
+}
+
+// parseTemplate parses the -template text, which must only use the fields.
+func parseTemplate(text string, fields []string) (*template.Template, error) {
+	t, err := template.New("record").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	// check the field names by executing on an empty record
+	if err := t.Execute(io.Discard, templateData(fields, nil)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// templateWriter writes each record as a line using a template.
+type templateWriter struct {
+	w      io.Writer
+	t      *template.Template
+	fields []string // fields that are always available to the template
+}
+
+func (tw *templateWriter) write(r record) error {
+	var sb strings.Builder
+	if err := tw.t.Execute(&sb, templateData(tw.fields, r.fields)); err != nil {
+		return fmt.Errorf("template: %w", err)
+	}
+	_, err := io.WriteString(tw.w, strings.TrimSuffix(sb.String(), "\n")+"\n")
+	return err
+}
+
+func (tw *templateWriter) flush() error { return nil }
+
+func (tw *templateWriter) close() error { return nil }
@@ -0,0 +1,130 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// hopNumber matches the hop number at the start of a line of traceroute
+// output, such as "  3  ", or mtr report output, such as "  3.|-- ".
+var hopNumber = regexp.MustCompile(`^\s*(\d+)(?:\.|\s)`)
+
+// runTraceroute runs the traceroute subcommand, which reads the output of
+// traceroute or mtr and locates the IPs of each hop. The output is written
+// as annotated text, with the location of each IP appended to its line, or
+// as CSV records of the hop, IP, and location.
+func runTraceroute(args []string) int {
+	fs := flag.NewFlagSet("traceroute", flag.ContinueOnError)
+	dbName := fs.String("db", "GeoLite2-City.mmdb", "Path to the GeoLite2 City database")
+	lang := fs.String("lang", "en", "Language for GeoIP lookup results.")
+	format := fs.String("format", "text", "Format of the output: text or csv.")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *format != "text" && *format != "csv" {
+		fmt.Fprintf(os.Stderr, "Invalid option: unknown -format %q\n", *format)
+		return exitUsage
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: iplookupdb traceroute [flags] [file]")
+		return exitUsage
+	}
+
+	db, err := geoip2.Open(*dbName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return exitDB
+	}
+	defer db.Close()
+
+	input, err := openInput(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open input: %v\n", err)
+		return exitInput
+	}
+	defer input.Close()
+
+	cfg := config{lang: *lang, delimiter: ',', private: privateLabel, dupes: dupesCache}
+	p := newProcessor(os.Stdout, cfg, db, nil)
+
+	if err := p.traceroute(input, *format == "csv"); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read input: %v\n", err)
+		return exitInput
+	}
+	p.w.Flush()
+	if err := p.w.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write output: %v\n", err)
+		return exitOutput
+	}
+	return exitOK
+}
+
+// traceroute locates the IPs of each hop in the traceroute or mtr output r.
+// Lines without a hop number, such as the additional responders of a hop
+// wrapped to the next line, belong to the previous hop. If records is set,
+// CSV records are written; otherwise each line is written with the location
+// of its IPs appended.
+func (p *processor) traceroute(r io.Reader, records bool) error {
+	if records {
+		p.w.Write([]string{"hop", "ip", "city", "subdivision", "country"})
+	}
+
+	hop := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := hopNumber.FindStringSubmatch(line); m != nil {
+			hop = m[1]
+		}
+
+		// hosts are written as name (ip), where the name may be the ip
+		addrs := slices.Compact(findIPs(line))
+		if hop == "" {
+			addrs = nil // header line
+		}
+
+		var summaries []string
+		for _, addr := range addrs {
+
+			addr = addr.Unmap()
+			loc, _, err := p.locate(addr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error for IP %v: %v\n", addr, err)
+			}
+			e := recordEnv(addr, "", loc)
+
+			if records {
+				fields := []string{hop, addr.String(), loc.city, loc.subdivision, loc.country}
+				for n := range fields {
+					if fields[n] == "" {
+						fields[n] = "unknown"
+					}
+				}
+				p.w.Write(fields)
+				continue
+			}
+
+			summary := annotation(defaultAnnotateFormat, e)
+			if len(addrs) > 1 {
+				summary = annotation(" [{ip} {country_iso}/{subdivision}/{city}]", e)
+			}
+			summaries = append(summaries, summary)
+		}
+
+		if !records {
+			fmt.Fprintln(p.out, line+strings.Join(summaries, ""))
+		}
+	}
+	return scanner.Err()
+}
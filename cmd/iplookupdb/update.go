@@ -0,0 +1,285 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Environment variables with the MaxMind credentials used by update.
+const (
+	envAccountID  = "MAXMIND_ACCOUNT_ID"
+	envLicenseKey = "MAXMIND_LICENSE_KEY"
+)
+
+// updateURL is the MaxMind download URL of an edition, with the suffix of
+// the file to download, tar.gz or tar.gz.sha256.
+const updateURL = "https://download.maxmind.com/geoip/databases/%s/download?suffix=%s"
+
+// updateCredentials returns the MaxMind credentials from the first of the
+// account ID and license key file flags, the environment, the GeoIP.conf
+// file conf, if any, or the OS keyring that has them. The editions in conf,
+// if any, are also returned.
+func updateCredentials(accountID, keyFile, conf string) (c credentials, editions []string, err error) {
+	if conf != "" {
+		c, editions, err = readGeoIPConf(conf)
+		if err != nil {
+			return credentials{}, nil, err
+		}
+	}
+
+	switch {
+	case accountID != "" && keyFile != "":
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return credentials{}, nil, err
+		}
+		return credentials{accountID, strings.TrimSpace(string(b))}, editions, nil
+	case accountID != "" || keyFile != "":
+		return credentials{}, nil, errors.New("-account-id and -license-key-file must be used together")
+	case os.Getenv(envAccountID) != "" && os.Getenv(envLicenseKey) != "":
+		return credentials{os.Getenv(envAccountID), os.Getenv(envLicenseKey)}, editions, nil
+	case c.AccountID != "" && c.LicenseKey != "":
+		return c, editions, nil
+	}
+
+	c, err = loadCredentials()
+	return c, editions, err
+}
+
+// readGeoIPConf reads the credentials and editions from name, which is in
+// the GeoIP.conf format used by geoipupdate, such as:
+//
+//	AccountID 123456
+//	LicenseKey 000000000000
+//	EditionIDs GeoLite2-ASN GeoLite2-City
+func readGeoIPConf(name string) (c credentials, editions []string, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return credentials{}, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "AccountID", "UserId":
+			c.AccountID = fields[1]
+		case "LicenseKey":
+			c.LicenseKey = fields[1]
+		case "EditionIDs", "ProductIds":
+			editions = fields[1:]
+		}
+	}
+	return c, editions, scanner.Err()
+}
+
+// updater downloads databases from MaxMind.
+type updater struct {
+	client *http.Client
+	creds  credentials
+}
+
+// get returns the body of the file with the suffix for the edition. The
+// caller must close the body.
+func (u *updater) get(edition, suffix string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(updateURL, edition, suffix), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(u.creds.AccountID, u.creds.LicenseKey)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// checksum returns the published SHA-256 of the archive of the edition.
+func (u *updater) checksum(edition string) (string, error) {
+	body, err := u.get(edition, "tar.gz.sha256")
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(body, 1024))
+	if err != nil {
+		return "", err
+	}
+	sum, _, _ := strings.Cut(strings.TrimSpace(string(b)), " ")
+	if len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid checksum %q", sum)
+	}
+	return strings.ToLower(sum), nil
+}
+
+// update downloads the edition, verifies its checksum, and replaces the
+// database in dir, which is named for the edition, such as
+// GeoLite2-City.mmdb. The database is written to a temporary file that is
+// checked and then renamed, so readers never see a partial database.
+func (u *updater) update(edition, dir string) (string, error) {
+	want, err := u.checksum(edition)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := u.get(edition, "tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	archive, err := os.CreateTemp(dir, "."+edition+"-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(archive, io.TeeReader(body, h)); err != nil {
+		return "", err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return "", fmt.Errorf("checksum %s does not match %s", got, want)
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(dir, edition+".mmdb")
+	tmp, err := extractMMDB(archive, dir, edition)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return name, nil
+}
+
+// extractMMDB extracts the .mmdb file of the edition from the tar.gz r to a
+// temporary file in dir, checks that it opens as a database, and returns
+// its name.
+func extractMMDB(r io.Reader, dir, edition string) (string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return "", errors.New("no .mmdb file in archive")
+		}
+		if err != nil {
+			return "", err
+		}
+		if hdr.Typeflag != tar.TypeReg || path.Ext(hdr.Name) != ".mmdb" {
+			continue
+		}
+
+		f, err := os.CreateTemp(dir, "."+edition+"-*.mmdb")
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(f, tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = checkMMDB(f.Name())
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+		return f.Name(), nil
+	}
+}
+
+// checkMMDB returns an error if name cannot be opened as a database.
+func checkMMDB(name string) error {
+	db, err := maxminddb.Open(name)
+	if err != nil {
+		return err
+	}
+	if err := db.Verify(); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// runUpdate runs the update subcommand, which downloads the databases from
+// MaxMind and atomically replaces the local copies, such as from cron.
+func runUpdate(args []string) int {
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "Directory of the databases.")
+	editionList := fs.String("editions", "GeoLite2-City", "Comma-separated editions to download, e.g., GeoLite2-City,GeoLite2-ASN.")
+	accountID := fs.String("account-id", "", "MaxMind account ID, used with -license-key-file.")
+	keyFile := fs.String("license-key-file", "", "File containing the MaxMind license key.")
+	conf := fs.String("config", "", "GeoIP.conf file with the credentials and editions.")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: iplookupdb update [flags]")
+		return exitUsage
+	}
+
+	creds, editions, err := updateCredentials(*accountID, *keyFile, *conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid credentials: %v\n", err)
+		return exitUsage
+	}
+	set := false
+	fs.Visit(func(f *flag.Flag) { set = set || f.Name == "editions" })
+	if set || len(editions) == 0 {
+		editions = strings.Split(*editionList, ",")
+	}
+
+	u := &updater{client: &http.Client{Timeout: 10 * time.Minute}, creds: creds}
+	status := exitOK
+	for _, edition := range editions {
+		edition = strings.TrimSpace(edition)
+		name, err := u.update(edition, *dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to update %s: %v\n", edition, err)
+			status = exitDB
+			continue
+		}
+		fmt.Printf("Updated %s\n", name)
+	}
+	return status
+}
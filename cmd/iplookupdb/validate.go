@@ -0,0 +1,206 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// maxReported is the maximum number of invalid inputs reported by validate.
+const maxReported = 10
+
+// validate performs a dry run using cfg and the IPs in args. It checks that
+// the databases open and are fresh, that the inputs are valid IPs, and that
+// the output can be created, reporting any problems to w. No lookups are
+// performed and nothing is written to the output.
+//
+// validate returns the number of problems found.
+func validate(w io.Writer, cfg config, args []string) int {
+	problems := 0
+	report := func(format string, a ...any) {
+		problems++
+		fmt.Fprintf(w, format+"\n", a...)
+	}
+
+	db, err := geoip2.Open(cfg.dbName)
+	if err != nil {
+		report("Failed to open database: %v", err)
+	} else {
+		if err := validateLang(db, cfg.lang); err != nil {
+			report("Invalid language: %v", err)
+		}
+		if err := checkAge(db, cfg.maxAge); err != nil {
+			report("Stale database: %v", err)
+		}
+		dbType, err := resolveDBType(db, cfg.dbType)
+		if err != nil {
+			report("Invalid database: %v", err)
+		} else {
+			fallbacks, err := openFallbacks(cfg.fallbackNames, dbType, cfg.lang, cfg.verbose)
+			if err != nil {
+				report("Invalid fallback database: %v", err)
+			}
+			closeFallbacks(fallbacks)
+		}
+		db.Close()
+	}
+
+	if err := openEnrichers(cfg.enrichers); err != nil {
+		report("Failed to open database: %v", err)
+	} else {
+		closeEnrichers(cfg.enrichers)
+	}
+
+	if cfg.private == privateInternal {
+		sites, err := openSiteDB(cfg.privateDB)
+		if err != nil {
+			report("Failed to open private database: %v", err)
+		} else {
+			sites.Close()
+		}
+	}
+
+	if cfg.resume {
+		if _, err := readCheckpoint(cfg.checkpoint); err != nil {
+			report("Cannot resume: %v", err)
+		}
+	}
+
+	if err := checkOutput(cfg.outputName, cfg.resume); err != nil {
+		report("Cannot create output: %v", err)
+	}
+
+	records, invalid := 0, 0
+	check := func(record string) {
+		records++
+		ipStr, found, err := recordIP(cfg, record, records == 1)
+		if err != nil {
+			invalid++
+			if invalid <= maxReported {
+				report("Invalid record %d: %v", records, err)
+			}
+			return
+		}
+		if !found {
+			return
+		}
+		if isRange(ipStr) {
+			_, _, err = parseRange(ipStr)
+		} else {
+			_, _, err = lookup.ParseIP(ipStr)
+		}
+		if err != nil && !cfg.skipInvalid {
+			invalid++
+			if invalid <= maxReported {
+				report("Invalid IP in record %d: %q", records, strings.TrimSpace(ipStr))
+			}
+		}
+	}
+
+	p := &processor{cfg: cfg}
+	if len(args) > 0 {
+		for _, arg := range args {
+			process, stop := p.next()
+			if stop {
+				break
+			}
+			if process {
+				check(arg)
+			}
+		}
+	} else {
+		input, err := openInput(cfg.inputName)
+		if err != nil {
+			report("Failed to open input: %v", err)
+		} else {
+			scanner := bufio.NewScanner(input)
+			for scanner.Scan() {
+				process, stop := p.next()
+				if stop {
+					break
+				}
+				if process {
+					check(scanner.Text())
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				report("Failed to read input: %v", err)
+			}
+			input.Close()
+		}
+	}
+
+	if invalid > maxReported {
+		problems += invalid - maxReported
+		fmt.Fprintf(w, "... and %d more invalid records\n", invalid-maxReported)
+	}
+
+	fmt.Fprintf(w, "Checked %d records, found %d problems\n", records, problems)
+	return problems
+}
+
+// recordIP returns the IP of the record, an input line or argument, in the
+// input mode of cfg, and reports whether it has one to look up. An error is
+// returned if the record itself is invalid, such as an -events line that is
+// not a JSON object. Lines of -annotate and -extract may have any number of
+// IPs, or none, so they are never invalid. first is true for the first
+// record, which is the header of -csv-in with -header.
+func recordIP(cfg config, record string, first bool) (ipStr string, found bool, err error) {
+	switch {
+	case cfg.annotate, cfg.extract:
+		return "", false, nil
+	case cfg.events:
+		var event map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(record), &event); err != nil || event == nil {
+			return "", false, errors.New("not a JSON object")
+		}
+		ipStr, found = eventIP(event, cfg.eventIP)
+		return ipStr, found, nil
+	case cfg.csvIn:
+		if first && cfg.header {
+			return "", false, nil
+		}
+		ipStr, err = csvRowIP(record, cfg.delimiter, cfg.ipColumn)
+		return ipStr, err == nil, err
+	}
+	if cfg.clean {
+		record = cleanIP(record)
+	}
+	return record, true, nil
+}
+
+// checkOutput returns an error if the output file name cannot be created.
+// The file must not exist, unless appendTo is true, and its directory must be
+// writable. An empty name is stdout, which is always valid.
+func checkOutput(name string, appendTo bool) error {
+	if name == "" {
+		return nil
+	}
+
+	_, err := os.Lstat(name)
+	if err == nil && !appendTo {
+		return fmt.Errorf("%s: %w", name, fs.ErrExist)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), ".iplookupdb-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
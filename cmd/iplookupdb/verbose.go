@@ -0,0 +1,48 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// verbose writes diagnostics to stderr if true.
+type verbose bool
+
+// printf writes the formatted diagnostic and a newline to stderr.
+func (v verbose) printf(format string, a ...any) {
+	if v {
+		fmt.Fprintf(os.Stderr, "verbose: "+format+"\n", a...)
+	}
+}
+
+// config writes the effective value of each flag.
+func (v verbose) config(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		v.printf("config: -%s=%q", f.Name, f.Value.String())
+	})
+}
+
+// metadata writes the metadata of the db opened from name.
+func (v verbose) metadata(name string, db *geoip2.Reader) {
+	m := db.Metadata()
+	v.printf("database %s: type %s, built %s, IPv%d, %d nodes, %d-bit records, languages %s",
+		name, m.DatabaseType,
+		time.Unix(int64(m.BuildEpoch), 0).UTC().Format(time.RFC3339),
+		m.IPVersion, m.NodeCount, m.RecordSize, strings.Join(m.Languages, ","))
+}
+
+// phase writes the time since start for the named phase and returns the
+// current time to use as the start of the next phase.
+func (v verbose) phase(name string, start time.Time) time.Time {
+	now := time.Now()
+	v.printf("%s took %s", name, now.Sub(start))
+	return now
+}
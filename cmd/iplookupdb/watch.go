@@ -0,0 +1,210 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Subdirectories of the watched directory for processed files.
+const (
+	watchDone   = "done"   // successfully processed files and their output
+	watchFailed = "failed" // files that could not be processed
+)
+
+// watchInterval is how often the watched directory is checked for new files.
+const watchInterval = 2 * time.Second
+
+// watcher processes each new file dropped into a directory.
+type watcher struct {
+	dir   string
+	cfg   config
+	db    geoDB
+	sites siteDB
+	v     verbose
+
+	cache    *locationCache // shared by all files
+	metrics  *metrics       // shared by all files
+	progress <-chan os.Signal
+
+	sizes map[string]int64 // size of each file when last seen
+	stuck map[string]bool  // processed files that could not be moved
+}
+
+// watchDir processes each new file dropped into dir until interrupted. The
+// output for a file is written to the done subdirectory as the file name
+// with the format appended, e.g., .csv, and the file is moved there. If the
+// file cannot be processed, it is moved to the failed subdirectory instead.
+// Files of the same name as earlier ones are numbered, as by uniqueName.
+func watchDir(dir string, cfg config, db geoDB, sites siteDB) error {
+	for _, sub := range []string{watchDone, watchFailed} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return err
+		}
+	}
+
+	w := &watcher{
+		dir:      dir,
+		cfg:      cfg,
+		db:       db,
+		sites:    sites,
+		v:        cfg.verbose,
+		progress: progressSignal(),
+		sizes:    make(map[string]int64),
+		stuck:    make(map[string]bool),
+	}
+	if cfg.dupes == dupesCache {
+		w.cache = newLocationCache(cfg.cacheSize)
+	}
+	if cfg.metricsAddr != "" {
+		w.metrics = newMetrics(cfg.metricsLabels)
+		if err := serveMetrics(cfg.metricsAddr, w.metrics); err != nil {
+			return err
+		}
+	}
+
+	for {
+		if err := w.scan(); err != nil {
+			return err
+		}
+		time.Sleep(watchInterval)
+	}
+}
+
+// scan processes the files in the directory that have not changed size
+// since the previous scan, which avoids processing files still being
+// written. Hidden files, such as temporary files, and files already
+// processed that could not be moved are ignored.
+func (w *watcher) scan() error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+
+	sizes := make(map[string]int64)
+	stuck := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if w.stuck[name] {
+			stuck[name] = true
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed since read
+		}
+
+		if size, seen := w.sizes[name]; !seen || size != info.Size() {
+			sizes[name] = info.Size()
+			continue
+		}
+
+		if !w.process(name) {
+			stuck[name] = true
+		}
+	}
+	w.sizes, w.stuck = sizes, stuck
+
+	return nil
+}
+
+// process processes the file name in the directory and moves it to the done
+// or failed subdirectory. It reports whether the file was moved, since a file
+// that was not is skipped by later scans rather than processed again.
+func (w *watcher) process(name string) bool {
+	start := time.Now()
+	path := filepath.Join(w.dir, name)
+	stem := w.uniqueName(name)
+	outName := filepath.Join(w.dir, watchDone, stem+"."+w.cfg.format)
+
+	p, err := w.processFile(path, outName)
+	dest := watchDone
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to process %s: %v\n", path, err)
+		os.Remove(outName)
+		dest = watchFailed
+	}
+
+	if err := os.Rename(path, filepath.Join(w.dir, dest, stem)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to move %s, skipping it until removed: %v\n", path, err)
+		return false
+	}
+
+	if err == nil {
+		w.v.printf("processed %s: %d records, %d written in %v",
+			path, p.records, p.written, time.Since(start).Round(time.Millisecond))
+	}
+	return true
+}
+
+// uniqueName returns name, or name with a number appended, such as
+// ips.txt.1, if a file of that name or its output is already in the done or
+// failed subdirectory, so earlier files of the same name are not
+// overwritten.
+func (w *watcher) uniqueName(name string) string {
+	exists := func(elem ...string) bool {
+		_, err := os.Lstat(filepath.Join(append([]string{w.dir}, elem...)...))
+		return !errors.Is(err, fs.ErrNotExist)
+	}
+	for n := 0; ; n++ {
+		stem := name
+		if n > 0 {
+			stem = fmt.Sprintf("%s.%d", name, n)
+		}
+		if !exists(watchDone, stem) && !exists(watchDone, stem+"."+w.cfg.format) &&
+			!exists(watchFailed, stem) {
+			return stem
+		}
+	}
+}
+
+// processFile processes the input file name, writing the output to outName.
+func (w *watcher) processFile(name, outName string) (*processor, error) {
+	input, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	output, err := os.Create(outName)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	encOutput, err := encodeOutput(output, w.cfg.encoding, true)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newProcessor(encOutput, w.cfg, w.db, w.sites)
+	p.progress = w.progress
+	p.metrics = w.metrics
+	if w.cache != nil {
+		p.cache = w.cache
+	}
+
+	err = p.processIPsFromInput(input)
+	p.finish()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.w.Error(); err != nil {
+		return nil, err
+	}
+	if err := flushEncoding(encOutput); err != nil {
+		return nil, err
+	}
+	return p, output.Close()
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+/*
+Liblookup is a C shared library of the lookup package, so programs in other
+languages, such as Python, Ruby, or PHP, can look up IPs the same way as
+iplookupdb without running it as a separate service.
+
+Build it with cgo, which writes the library and a liblookup.h header:
+
+	go build -buildmode=c-shared -o liblookup.so ./cmd/liblookup
+
+It exports these functions:
+
+	char *lookup_open(char *path);
+	void lookup_set_lang(char *lang);
+	char *lookup_json(char *ip);
+	void lookup_free(char *s);
+	void lookup_close(void);
+
+lookup_open opens the City database at path, closing any database already
+open, and returns NULL or the error. lookup_set_lang sets the language of
+names, as for the Lang field of lookup.Looker, which is en by default.
+lookup_json returns the location of ip as a JSON object with the
+same fields as the json output of iplookupdb, such as country_iso, or an
+object with only an error field if ip is invalid or cannot be looked up.
+Strings returned by the library must be freed with lookup_free.
+lookup_close closes the database. The functions are safe for concurrent use.
+
+For example, in Python:
+
+	import ctypes, json
+
+	lib = ctypes.CDLL("./liblookup.so")
+	lib.lookup_open.restype = ctypes.c_void_p
+	lib.lookup_json.restype = ctypes.c_void_p
+
+	err = lib.lookup_open(b"GeoLite2-City.mmdb")
+	if err:
+	    raise RuntimeError(ctypes.string_at(err).decode())
+
+	p = lib.lookup_json(b"81.2.69.142")
+	record = json.loads(ctypes.string_at(p))
+	lib.lookup_free(ctypes.c_void_p(p))
+*/
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+)
+
+var (
+	mu     sync.RWMutex
+	looker *lookup.Looker // nil until lookup_open
+	lang   = "en"
+)
+
+//export lookup_open
+func lookup_open(path *C.char) *C.char {
+	l, err := lookup.Open(C.GoString(path))
+	if err != nil {
+		return C.CString(err.Error())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if looker != nil {
+		looker.Close()
+	}
+	l.Lang = lang
+	looker = l
+	return nil
+}
+
+//export lookup_set_lang
+func lookup_set_lang(l *C.char) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lang = C.GoString(l)
+	if looker != nil {
+		looker.Lang = lang
+	}
+}
+
+//export lookup_json
+func lookup_json(ip *C.char) *C.char {
+	b, err := lookupJSON(C.GoString(ip))
+	if err != nil {
+		b, _ = json.Marshal(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+	}
+	return C.CString(string(b))
+}
+
+// lookupJSON returns the location of ip as JSON.
+func lookupJSON(ip string) ([]byte, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if looker == nil {
+		return nil, errors.New("no database is open")
+	}
+	r, err := looker.LookupString(ip)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(r)
+}
+
+//export lookup_free
+func lookup_free(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+//export lookup_close
+func lookup_close() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if looker != nil {
+		looker.Close()
+		looker = nil
+	}
+}
+
+func main() {}
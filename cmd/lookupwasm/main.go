@@ -0,0 +1,135 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+//go:build js && wasm
+
+/*
+Lookupwasm is a WebAssembly module of the lookup package, so browser tools
+and edge workers can look up IPs the same way as iplookupdb, with a City
+database supplied by the host as bytes.
+
+Build it for JavaScript hosts and copy the wasm_exec.js support file of the
+Go distribution used:
+
+	GOOS=js GOARCH=wasm go build -o lookup.wasm ./cmd/lookupwasm
+	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" .
+
+Once run, it sets a global iplookupdb object with these methods:
+
+	open(mmdb)     // open the City db in the Uint8Array mmdb
+	setLang(lang)  // set the language of names, en by default
+	lookup(ip)     // return the location of ip as a JSON string
+	close()        // close the db
+
+open returns null or the error. setLang takes a language as for the Lang
+field of lookup.Looker. lookup returns a JSON object with the same fields as
+the json output of iplookupdb, such as country_iso, or an object with only
+an error field if ip is invalid or cannot be looked up.
+
+For example:
+
+	const go = new Go();
+	const wasm = await WebAssembly.instantiateStreaming(fetch("lookup.wasm"), go.importObject);
+	go.run(wasm.instance);
+
+	const mmdb = new Uint8Array(await (await fetch("GeoLite2-City.mmdb")).arrayBuffer());
+	const err = iplookupdb.open(mmdb);
+	if (err) throw new Error(err);
+	const record = JSON.parse(iplookupdb.lookup("81.2.69.142"));
+
+Go programs compiled for WASI, with GOOS=wasip1, can use the lookup package
+directly, opening a db supplied by the host with lookup.FromBytes.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"syscall/js"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+)
+
+var (
+	looker *lookup.Looker // nil until open
+	lang   = "en"
+)
+
+func main() {
+	js.Global().Set("iplookupdb", js.ValueOf(map[string]any{
+		"open":    js.FuncOf(open),
+		"setLang": js.FuncOf(setLang),
+		"lookup":  js.FuncOf(lookupJSON),
+		"close":   js.FuncOf(closeDB),
+	}))
+
+	select {} // serve calls from the host
+}
+
+// open opens the City db in the Uint8Array args[0], closing any db already
+// open, and returns null or the error.
+func open(this js.Value, args []js.Value) any {
+	if len(args) != 1 || args[0].Type() != js.TypeObject {
+		return "open needs the database as a Uint8Array"
+	}
+	b := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(b, args[0])
+
+	l, err := lookup.FromBytes(b)
+	if err != nil {
+		return err.Error()
+	}
+	closeDB(js.Undefined(), nil)
+	l.Lang = lang
+	looker = l
+	return nil
+}
+
+// setLang sets the language of names to args[0].
+func setLang(this js.Value, args []js.Value) any {
+	if len(args) == 1 {
+		lang = args[0].String()
+	}
+	if looker != nil {
+		looker.Lang = lang
+	}
+	return nil
+}
+
+// lookupJSON returns the location of the IP args[0] as a JSON string, or an
+// object with the error.
+func lookupJSON(this js.Value, args []js.Value) any {
+	var ip string
+	if len(args) == 1 {
+		ip = args[0].String()
+	}
+
+	b, err := recordJSON(ip)
+	if err != nil {
+		b, _ = json.Marshal(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+	}
+	return string(b)
+}
+
+// recordJSON returns the location of ip as JSON.
+func recordJSON(ip string) ([]byte, error) {
+	if looker == nil {
+		return nil, errors.New("no database is open")
+	}
+	r, err := looker.LookupString(ip)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(r)
+}
+
+// closeDB closes the db, if open.
+func closeDB(this js.Value, args []js.Value) any {
+	if looker != nil {
+		looker.Close()
+		looker = nil
+	}
+	return nil
+}
@@ -2,9 +2,31 @@ module github.com/bnixon67/iplookupdb
 
 go 1.22.1
 
-require github.com/oschwald/geoip2-golang v1.9.0
+require (
+	github.com/maxmind/mmdbwriter v1.0.0
+	github.com/oschwald/geoip2-golang v1.9.0
+	github.com/oschwald/maxminddb-golang v1.12.0
+	github.com/parquet-go/parquet-go v0.24.0
+	github.com/zalando/go-keyring v0.2.8
+	go.starlark.net v0.0.0-20240725214946-42030a7cedce
+	golang.org/x/sys v0.28.0
+	golang.org/x/term v0.27.0
+	golang.org/x/text v0.17.0
+	google.golang.org/grpc v1.67.3
+	google.golang.org/protobuf v1.34.2
+)
 
 require (
-	github.com/oschwald/maxminddb-golang v1.11.0 // indirect
-	golang.org/x/sys v0.9.0 // indirect
+	github.com/andybalholm/brotli v1.1.0 // indirect
+	github.com/danieljoos/wincred v1.2.3 // indirect
+	github.com/godbus/dbus/v5 v5.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/mattn/go-runewidth v0.0.15 // indirect
+	github.com/olekukonko/tablewriter v0.0.5 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
+	go4.org/netipx v0.0.0-20220812043211-3cc044ffd68d // indirect
+	golang.org/x/net v0.28.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240814211410-ddb44dafa142 // indirect
 )
@@ -0,0 +1,68 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package lookup
+
+import (
+	"sort"
+	"sync"
+)
+
+// Enricher is a stage that adds fields to each record after the lookup, such
+// as the owner of an IP from an internal asset db or CMDB.
+type Enricher interface {
+	// Columns returns the names of the fields added by Enrich.
+	Columns() []string
+
+	// Enrich adds fields to the record fields, which are keyed by field
+	// name, such as ip and country_iso. Values are a string, float64, bool,
+	// or nil if missing. Since records are enriched concurrently, Enrich
+	// must be safe for concurrent use.
+	Enrich(fields map[string]any) error
+}
+
+var (
+	enrichersMu sync.Mutex
+	enrichers   = map[string]Enricher{}
+)
+
+// RegisterEnricher makes the Enricher e available by name, such as to the
+// -enrich flag of the iplookupdb command.
+//
+// Custom enrichers are added to the command by building it with a file that
+// calls RegisterEnricher from an init function. If RegisterEnricher is
+// called twice with the same name or if e is nil, it panics.
+func RegisterEnricher(name string, e Enricher) {
+	enrichersMu.Lock()
+	defer enrichersMu.Unlock()
+
+	if e == nil {
+		panic("lookup: RegisterEnricher enricher is nil")
+	}
+	if _, dup := enrichers[name]; dup {
+		panic("lookup: RegisterEnricher called twice for enricher " + name)
+	}
+	enrichers[name] = e
+}
+
+// RegisteredEnricher returns the Enricher registered as name, or nil if
+// there is none.
+func RegisteredEnricher(name string) Enricher {
+	enrichersMu.Lock()
+	defer enrichersMu.Unlock()
+
+	return enrichers[name]
+}
+
+// EnricherNames returns the sorted names of the registered enrichers.
+func EnricherNames() []string {
+	enrichersMu.Lock()
+	defer enrichersMu.Unlock()
+
+	names := make([]string, 0, len(enrichers))
+	for name := range enrichers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package lookup
+
+import (
+	"slices"
+	"testing"
+)
+
+type ownerEnricher struct{}
+
+func (ownerEnricher) Columns() []string { return []string{"owner"} }
+
+func (ownerEnricher) Enrich(fields map[string]any) error {
+	fields["owner"] = "netops"
+	return nil
+}
+
+func TestRegisterEnricher(t *testing.T) {
+	RegisterEnricher("test-owner", ownerEnricher{})
+
+	if e := RegisteredEnricher("test-owner"); e == nil {
+		t.Error("RegisteredEnricher(test-owner) = nil, want the enricher")
+	}
+	if e := RegisteredEnricher("test-missing"); e != nil {
+		t.Errorf("RegisteredEnricher(test-missing) = %v, want nil", e)
+	}
+	if names := EnricherNames(); !slices.Contains(names, "test-owner") {
+		t.Errorf("EnricherNames() = %v, want test-owner", names)
+	}
+
+	for name, e := range map[string]Enricher{"test-owner": ownerEnricher{}, "test-nil": nil} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterEnricher(%s, %v) did not panic", name, e)
+				}
+			}()
+			RegisterEnricher(name, e)
+		}()
+	}
+}
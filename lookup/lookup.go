@@ -0,0 +1,222 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+// Package lookup looks up the location of IP addresses in a MaxMind GeoIP2 or
+// GeoLite2 City database, for use by Go programs without the iplookupdb
+// command.
+//
+// For example:
+//
+//	l, err := lookup.Open("GeoLite2-City.mmdb")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer l.Close()
+//
+//	r, err := l.LookupString("81.2.69.142")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	fmt.Println(r.City, r.Country) // London United Kingdom
+package lookup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Record is the location of an IP. Names are empty if they are unknown.
+type Record struct {
+	IP            netip.Addr
+	City          string
+	Subdivision   string
+	Country       string
+	CountryISO    string // ISO 3166-1 country code
+	Continent     string
+	ContinentCode string // e.g., EU
+	Postal        string
+	TimeZone      string // IANA time zone, e.g., Europe/London
+
+	// coordinates, which are only known if HasCoordinates is true
+	Latitude       float64
+	Longitude      float64
+	AccuracyRadius uint16 // in kilometers
+	HasCoordinates bool
+}
+
+// MarshalJSON returns r as a JSON object with the field names of the json
+// output of the iplookupdb command, such as country_iso. The coordinates are
+// null if unknown.
+func (r Record) MarshalJSON() ([]byte, error) {
+	var lat, lon *float64
+	var radius *uint16
+	if r.HasCoordinates {
+		lat, lon, radius = &r.Latitude, &r.Longitude, &r.AccuracyRadius
+	}
+
+	return json.Marshal(struct {
+		IP             netip.Addr `json:"ip"`
+		City           string     `json:"city"`
+		Subdivision    string     `json:"subdivision"`
+		Country        string     `json:"country"`
+		CountryISO     string     `json:"country_iso"`
+		Continent      string     `json:"continent"`
+		ContinentCode  string     `json:"continent_code"`
+		Postal         string     `json:"postal"`
+		TimeZone       string     `json:"timezone"`
+		Latitude       *float64   `json:"latitude"`
+		Longitude      *float64   `json:"longitude"`
+		AccuracyRadius *uint16    `json:"accuracy_radius"`
+	}{
+		r.IP, r.City, r.Subdivision, r.Country, r.CountryISO, r.Continent,
+		r.ContinentCode, r.Postal, r.TimeZone, lat, lon, radius,
+	})
+}
+
+// Name returns the name in names for the first language in lang, a
+// comma-separated list of languages such as ja,en, that has one, or "" if
+// none do.
+func Name(names map[string]string, lang string) string {
+	for lang != "" {
+		var l string
+		l, lang, _ = strings.Cut(lang, ",")
+		if name := names[strings.TrimSpace(l)]; name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// CityRecord returns the Record for ip from the City db record with names
+// in lang, which may be a list of languages as for Name.
+func CityRecord(ip netip.Addr, city *geoip2.City, lang string) Record {
+	r := Record{
+		IP:             ip,
+		City:           Name(city.City.Names, lang),
+		Country:        Name(city.Country.Names, lang),
+		CountryISO:     city.Country.IsoCode,
+		Continent:      Name(city.Continent.Names, lang),
+		ContinentCode:  city.Continent.Code,
+		Postal:         city.Postal.Code,
+		TimeZone:       city.Location.TimeZone,
+		Latitude:       city.Location.Latitude,
+		Longitude:      city.Location.Longitude,
+		AccuracyRadius: city.Location.AccuracyRadius,
+	}
+	r.HasCoordinates = r.AccuracyRadius != 0 || r.Latitude != 0 || r.Longitude != 0
+	if len(city.Subdivisions) > 0 {
+		r.Subdivision = Name(city.Subdivisions[0].Names, lang)
+	}
+	return r
+}
+
+// Looker looks up IPs in a City db. It is safe for concurrent use.
+type Looker struct {
+	db *geoip2.Reader
+
+	// Lang is the language of names, such as en or de, or a
+	// comma-separated list of languages to try in order, such as ja,en.
+	// Names not available in any of them are empty.
+	Lang string
+}
+
+// Open opens the City db name, looking up names in English.
+func Open(name string) (*Looker, error) {
+	db, err := geoip2.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return New(db), nil
+}
+
+// FromBytes returns a Looker for the City db in b, such as one supplied by
+// the host of a WebAssembly module, looking up names in English. b must not
+// be changed while the Looker is in use.
+func FromBytes(b []byte) (*Looker, error) {
+	db, err := geoip2.FromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return New(db), nil
+}
+
+// New returns a Looker for the open db, looking up names in English.
+func New(db *geoip2.Reader) *Looker {
+	return &Looker{db: db, Lang: "en"}
+}
+
+// DB returns the db, such as to read its metadata.
+func (l *Looker) DB() *geoip2.Reader {
+	return l.db
+}
+
+// Close closes the db.
+func (l *Looker) Close() error {
+	return l.db.Close()
+}
+
+// Lookup returns the location of ip, which is normalized by Normalize, so
+// IPv4-mapped IPv6 addresses are looked up and returned as IPv4 and any zone
+// is stripped.
+func (l *Looker) Lookup(ip netip.Addr) (Record, error) {
+	if !ip.IsValid() {
+		return Record{}, errors.New("invalid IP")
+	}
+	ip = Normalize(ip)
+
+	city, err := l.db.City(net.IP(ip.AsSlice()))
+	if err != nil {
+		return Record{}, err
+	}
+	return CityRecord(ip, city, l.Lang), nil
+}
+
+// LookupNetIP returns the location of ip, such as the address of a
+// net.Conn, as Lookup does.
+func (l *Looker) LookupNetIP(ip net.IP) (Record, error) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return Record{}, fmt.Errorf("invalid IP %v", ip)
+	}
+	return l.Lookup(addr)
+}
+
+// LookupString returns the location of the IP in s, which is parsed by
+// ParseIP. Any port is ignored.
+func (l *Looker) LookupString(s string) (Record, error) {
+	ip, _, err := ParseIP(s)
+	if err != nil {
+		return Record{}, err
+	}
+	return l.Lookup(ip)
+}
+
+// Stream looks up the IP on each line of r, calling fn with the Record or
+// the error for the line, until fn returns an error or r is exhausted.
+// Blank lines are skipped. Stream returns the error from fn or reading r.
+func (l *Looker) Stream(r io.Reader, fn func(Record, error) error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+
+		record, err := l.LookupString(s)
+		if err != nil {
+			err = fmt.Errorf("line %d: %q: %w", line, s, err)
+		}
+		if err := fn(record, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package lookup_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+	"github.com/bnixon67/iplookupdb/testdb"
+)
+
+// openTestDB returns a Looker for the test database, closed when t ends.
+func openTestDB(t *testing.T) *lookup.Looker {
+	t.Helper()
+	l, err := testdb.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestLookup(t *testing.T) {
+	l := openTestDB(t)
+
+	tests := []struct {
+		ip   string
+		lang string
+		want lookup.Record // IP is set from ip
+	}{
+		{
+			ip: "81.2.69.142",
+			want: lookup.Record{
+				City: "London", Subdivision: "England",
+				Country: "United Kingdom", CountryISO: "GB",
+				Continent: "Europe", ContinentCode: "EU",
+				TimeZone: "Europe/London",
+				Latitude: 51.5142, Longitude: -0.0931,
+				AccuracyRadius: 100, HasCoordinates: true,
+			},
+		},
+		{
+			ip: "216.160.83.56",
+			want: lookup.Record{
+				City: "Milton", Subdivision: "Washington",
+				Country: "United States", CountryISO: "US",
+				Continent: "North America", ContinentCode: "NA",
+				Postal: "98354", TimeZone: "America/Los_Angeles",
+				Latitude: 47.2513, Longitude: -122.3149,
+				AccuracyRadius: 100, HasCoordinates: true,
+			},
+		},
+		{
+			// England is only named in English
+			ip:   "81.2.69.142",
+			lang: "de,en",
+			want: lookup.Record{
+				City: "London", Subdivision: "England",
+				Country: "Vereinigtes Königreich", CountryISO: "GB",
+				Continent: "Europa", ContinentCode: "EU",
+				TimeZone: "Europe/London",
+				Latitude: 51.5142, Longitude: -0.0931,
+				AccuracyRadius: 100, HasCoordinates: true,
+			},
+		},
+		{
+			ip:   "2001:218::",
+			lang: "ja,en",
+			want: lookup.Record{
+				Country: "日本", CountryISO: "JP",
+				Continent: "アジア", ContinentCode: "AS",
+				TimeZone: "Asia/Tokyo",
+				Latitude: 35.68536, Longitude: 139.75309,
+				AccuracyRadius: 100, HasCoordinates: true,
+			},
+		},
+		{
+			// not in the db
+			ip:   "1.1.1.1",
+			want: lookup.Record{},
+		},
+	}
+
+	for _, tt := range tests {
+		l.Lang = "en"
+		if tt.lang != "" {
+			l.Lang = tt.lang
+		}
+		got, err := l.Lookup(netip.MustParseAddr(tt.ip))
+		if err != nil {
+			t.Errorf("Lookup(%s) error: %v", tt.ip, err)
+			continue
+		}
+		tt.want.IP = netip.MustParseAddr(tt.ip)
+		if got != tt.want {
+			t.Errorf("Lookup(%s) with Lang %q =\n%+v\nwant\n%+v", tt.ip, l.Lang, got, tt.want)
+		}
+	}
+}
+
+func TestLookupNormalizes(t *testing.T) {
+	l := openTestDB(t)
+
+	for _, ip := range []string{"::ffff:81.2.69.142", "::ffff:81.2.69.142%eth0"} {
+		r, err := l.Lookup(netip.MustParseAddr(ip))
+		if err != nil {
+			t.Errorf("Lookup(%s) error: %v", ip, err)
+			continue
+		}
+		if r.IP.String() != "81.2.69.142" || r.CountryISO != "GB" {
+			t.Errorf("Lookup(%s) = %v %q, want 81.2.69.142 GB", ip, r.IP, r.CountryISO)
+		}
+	}
+
+	if _, err := l.Lookup(netip.Addr{}); err == nil {
+		t.Error("Lookup of the zero Addr succeeded, want error")
+	}
+}
+
+func TestLookupNetIP(t *testing.T) {
+	l := openTestDB(t)
+
+	r, err := l.LookupNetIP(net.ParseIP("89.160.20.112"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.IP.String() != "89.160.20.112" || r.CountryISO != "SE" {
+		t.Errorf("LookupNetIP = %v %q, want 89.160.20.112 SE", r.IP, r.CountryISO)
+	}
+
+	if _, err := l.LookupNetIP(net.IP{1, 2, 3}); err == nil {
+		t.Error("LookupNetIP of a 3-byte IP succeeded, want error")
+	}
+}
+
+func TestLookupString(t *testing.T) {
+	l := openTestDB(t)
+
+	tests := []struct {
+		in      string
+		wantISO string
+		wantErr bool
+	}{
+		{in: "175.16.199.0", wantISO: "CN"},
+		{in: " 81.2.69.142:443 ", wantISO: "GB"},
+		{in: "[2001:218::]:80", wantISO: "JP"},
+		{in: "bogus", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		r, err := l.LookupString(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("LookupString(%q) error = %v, want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if r.CountryISO != tt.wantISO {
+			t.Errorf("LookupString(%q) country = %q, want %q", tt.in, r.CountryISO, tt.wantISO)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	l := openTestDB(t)
+
+	in := "81.2.69.142\n\nbogus\n2001:218::\n"
+	var isos []string
+	var errs int
+	err := l.Stream(strings.NewReader(in), func(r lookup.Record, err error) error {
+		if err != nil {
+			errs++
+			if !strings.Contains(err.Error(), "line 3") {
+				t.Errorf("error %q does not give the line", err)
+			}
+			return nil
+		}
+		isos = append(isos, r.CountryISO)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(isos, ",") != "GB,JP" || errs != 1 {
+		t.Errorf("Stream = %v with %d errors, want [GB JP] with 1", isos, errs)
+	}
+
+	stop := errors.New("stop")
+	err = l.Stream(strings.NewReader(in), func(lookup.Record, error) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("Stream = %v, want the error from fn", err)
+	}
+}
+
+func TestRecordMarshalJSON(t *testing.T) {
+	tests := []struct {
+		r    lookup.Record
+		want string
+	}{
+		{
+			lookup.Record{
+				IP: netip.MustParseAddr("81.2.69.142"), City: "London",
+				CountryISO: "GB", Latitude: 51.5142, Longitude: -0.0931,
+				AccuracyRadius: 100, HasCoordinates: true,
+			},
+			`{"ip":"81.2.69.142","city":"London","subdivision":"","country":"",` +
+				`"country_iso":"GB","continent":"","continent_code":"","postal":"",` +
+				`"timezone":"","latitude":51.5142,"longitude":-0.0931,"accuracy_radius":100}`,
+		},
+		{
+			// coordinates of 0 are unknown unless HasCoordinates
+			lookup.Record{IP: netip.MustParseAddr("1.1.1.1")},
+			`{"ip":"1.1.1.1","city":"","subdivision":"","country":"",` +
+				`"country_iso":"","continent":"","continent_code":"","postal":"",` +
+				`"timezone":"","latitude":null,"longitude":null,"accuracy_radius":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.r)
+		if err != nil {
+			t.Errorf("Marshal(%v) error: %v", tt.r.IP, err)
+			continue
+		}
+		if string(b) != tt.want {
+			t.Errorf("Marshal(%v) =\n%s\nwant\n%s", tt.r.IP, b, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package lookup
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Normalize returns ip in the form it is looked up in, without any IPv6 zone,
+// such as fe80::1 for fe80::1%eth0, and with IPv4-mapped IPv6 addresses,
+// such as ::ffff:192.0.2.1, as IPv4.
+func Normalize(ip netip.Addr) netip.Addr {
+	return ip.WithZone("").Unmap()
+}
+
+// ParseIP parses s as an IP address after trimming surrounding white space.
+//
+// Besides the usual notations, IPv4 addresses may be given as a decimal or
+// hexadecimal integer, such as 3221225985 or 0xC0000201 for 192.0.2.1. The
+// integer must be at least 16777216 (0x1000000), which is 1.0.0.0, so small
+// numbers, such as a port or a count, are not taken as an IP.
+//
+// The IP may include a port, such as 203.0.113.5:443 or [2001:db8::1]:8080,
+// which is stripped from the address and returned as port. If s does not
+// include a port, then port is empty.
+//
+// Any IPv6 zone, such as eth0 in fe80::1%eth0, is stripped since it only
+// identifies a local interface. IPv4-mapped IPv6 addresses are returned as
+// given; use Normalize to convert them to IPv4.
+func ParseIP(s string) (addr netip.Addr, port string, err error) {
+	s = strings.TrimSpace(s)
+
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		addr = addrPort.Addr()
+		port = strconv.Itoa(int(addrPort.Port()))
+	} else if a, ok := parseIntIPv4(s); ok {
+		addr = a
+	} else {
+		addr, err = netip.ParseAddr(s)
+		if err != nil {
+			return netip.Addr{}, "", err
+		}
+	}
+
+	return addr.WithZone(""), port, nil
+}
+
+// minIntIPv4 is the smallest integer taken as an IPv4 address, 1.0.0.0, since
+// smaller ones are more likely to be some other number. The addresses below it
+// are in 0.0.0.0/8, which are not valid destinations.
+const minIntIPv4 = 1 << 24
+
+// parseIntIPv4 parses s as an IPv4 address in decimal or 0x-prefixed
+// hexadecimal integer notation, as found in some proxy logs and malware
+// configs. If s is not in either notation or is less than minIntIPv4, then ok
+// is false.
+func parseIntIPv4(s string) (addr netip.Addr, ok bool) {
+	base := 10
+	if hex, found := strings.CutPrefix(strings.ToLower(s), "0x"); found {
+		s, base = hex, 16
+	} else if strings.ContainsFunc(s, func(r rune) bool { return r < '0' || r > '9' }) {
+		return netip.Addr{}, false
+	}
+
+	n, err := strconv.ParseUint(s, base, 32)
+	if err != nil || n < minIntIPv4 {
+		return netip.Addr{}, false
+	}
+
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	return netip.AddrFrom4(b), true
+}
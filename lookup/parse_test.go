@@ -0,0 +1,100 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+package lookup
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseIP(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		port    string
+		wantErr bool
+	}{
+		{in: "81.2.69.142", want: "81.2.69.142"},
+		{in: "  81.2.69.142\t", want: "81.2.69.142"},
+		{in: "2001:db8::1", want: "2001:db8::1"},
+		{in: "::ffff:192.0.2.1", want: "::ffff:192.0.2.1"},
+		{in: "203.0.113.5:443", want: "203.0.113.5", port: "443"},
+		{in: "[2001:db8::1]:8080", want: "2001:db8::1", port: "8080"},
+		{in: "fe80::1%eth0", want: "fe80::1"},
+		{in: "[fe80::1%eth0]:22", want: "fe80::1", port: "22"},
+		{in: "3221225985", want: "192.0.2.1"},
+		{in: "0xC0000201", want: "192.0.2.1"},
+		{in: "0xc0000201", want: "192.0.2.1"},
+		{in: "", wantErr: true},
+		{in: "not an ip", wantErr: true},
+		{in: "256.1.1.1", wantErr: true},
+		{in: "16777216", want: "1.0.0.0"},
+		{in: "4294967295", want: "255.255.255.255"},
+		{in: "4294967296", wantErr: true},
+		{in: "80", wantErr: true},
+		{in: "16777215", wantErr: true},
+		{in: "0xFFFFFF", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "0x", wantErr: true},
+		{in: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		addr, port, err := ParseIP(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseIP(%q) = %v, %q, want error", tt.in, addr, port)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseIP(%q) error: %v", tt.in, err)
+			continue
+		}
+		if addr.String() != tt.want || port != tt.port {
+			t.Errorf("ParseIP(%q) = %v, %q, want %s, %q", tt.in, addr, port, tt.want, tt.port)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"81.2.69.142", "81.2.69.142"},
+		{"::ffff:81.2.69.142", "81.2.69.142"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"::ffff:192.0.2.1%eth0", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		got := Normalize(netip.MustParseAddr(tt.in))
+		if got.String() != tt.want {
+			t.Errorf("Normalize(%s) = %v, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	names := map[string]string{"en": "Japan", "ja": "日本"}
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"en", "Japan"},
+		{"ja", "日本"},
+		{"de", ""},
+		{"de,en", "Japan"},
+		{"de, ja, en", "日本"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Name(names, tt.lang); got != tt.want {
+			t.Errorf("Name(%q) = %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+// gen writes the test database embedded by package testdb.
+//
+// Usage:
+//
+//	go run ./internal/gen -o GeoIP2-City-Test.mmdb
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// buildEpoch is fixed so the generated database is reproducible.
+const buildEpoch = 1704067200 // 2024-01-01T00:00:00Z
+
+// place is a city used to build a GeoIP2 City record.
+type place struct {
+	network   string
+	city      map[string]string
+	subISO    string
+	sub       map[string]string
+	countryCC string
+	country   map[string]string
+	continent string
+	lat, lon  float64
+	timeZone  string
+	postal    string
+}
+
+// places are the addresses documented in the MaxMind test databases, plus
+// well-known public IPs used by the selftest subcommand.
+var places = []place{
+	{
+		network: "81.2.69.142/31",
+		city:    map[string]string{"en": "London", "de": "London", "ja": "ロンドン"},
+		subISO:  "ENG", sub: map[string]string{"en": "England"},
+		countryCC: "GB", country: map[string]string{"en": "United Kingdom", "de": "Vereinigtes Königreich", "ja": "イギリス"},
+		continent: "EU", lat: 51.5142, lon: -0.0931, timeZone: "Europe/London",
+	},
+	{
+		network: "2.125.160.216/29",
+		city:    map[string]string{"en": "Boxford"},
+		subISO:  "WBK", sub: map[string]string{"en": "West Berkshire"},
+		countryCC: "GB", country: map[string]string{"en": "United Kingdom", "de": "Vereinigtes Königreich", "ja": "イギリス"},
+		continent: "EU", lat: 51.75, lon: -1.25, timeZone: "Europe/London", postal: "OX1",
+	},
+	{
+		network: "89.160.20.112/28",
+		city:    map[string]string{"en": "Linköping", "de": "Linköping"},
+		subISO:  "E", sub: map[string]string{"en": "Östergötland County"},
+		countryCC: "SE", country: map[string]string{"en": "Sweden", "de": "Schweden", "ja": "スウェーデン王国"},
+		continent: "EU", lat: 58.4167, lon: 15.6167, timeZone: "Europe/Stockholm",
+	},
+	{
+		network: "175.16.199.0/24",
+		city:    map[string]string{"en": "Changchun", "de": "Changchun", "ja": "長春市"},
+		subISO:  "22", sub: map[string]string{"en": "Jilin Sheng"},
+		countryCC: "CN", country: map[string]string{"en": "China", "de": "China", "ja": "中国"},
+		continent: "AS", lat: 43.88, lon: 125.3228, timeZone: "Asia/Harbin",
+	},
+	{
+		network: "216.160.83.56/29",
+		city:    map[string]string{"en": "Milton", "ja": "ミルトン"},
+		subISO:  "WA", sub: map[string]string{"en": "Washington", "ja": "ワシントン州"},
+		countryCC: "US", country: map[string]string{"en": "United States", "de": "USA", "ja": "アメリカ合衆国"},
+		continent: "NA", lat: 47.2513, lon: -122.3149, timeZone: "America/Los_Angeles", postal: "98354",
+	},
+	{
+		network:   "2001:218::/32",
+		countryCC: "JP", country: map[string]string{"en": "Japan", "de": "Japan", "ja": "日本"},
+		continent: "AS", lat: 35.68536, lon: 139.75309, timeZone: "Asia/Tokyo",
+	},
+	{
+		network: "8.8.8.0/24",
+		city:    map[string]string{"en": "Mountain View"},
+		subISO:  "CA", sub: map[string]string{"en": "California"},
+		countryCC: "US", country: map[string]string{"en": "United States", "de": "USA", "ja": "アメリカ合衆国"},
+		continent: "NA", lat: 37.386, lon: -122.0838, timeZone: "America/Los_Angeles", postal: "94035",
+	},
+	{
+		network:   "208.67.222.0/24",
+		countryCC: "US", country: map[string]string{"en": "United States", "de": "USA", "ja": "アメリカ合衆国"},
+		continent: "NA", lat: 37.751, lon: -97.822, timeZone: "America/Chicago",
+	},
+}
+
+var continents = map[string]map[string]string{
+	"AS": {"en": "Asia", "de": "Asien", "ja": "アジア"},
+	"EU": {"en": "Europe", "de": "Europa", "ja": "ヨーロッパ"},
+	"NA": {"en": "North America", "de": "Nordamerika", "ja": "北アメリカ"},
+}
+
+func names(m map[string]string) mmdbtype.Map {
+	names := mmdbtype.Map{}
+	for lang, name := range m {
+		names[mmdbtype.String(lang)] = mmdbtype.String(name)
+	}
+	return names
+}
+
+// record returns the GeoIP2 City record for p.
+func (p place) record() mmdbtype.Map {
+	r := mmdbtype.Map{
+		"continent": mmdbtype.Map{
+			"code":  mmdbtype.String(p.continent),
+			"names": names(continents[p.continent]),
+		},
+		"country": mmdbtype.Map{
+			"iso_code": mmdbtype.String(p.countryCC),
+			"names":    names(p.country),
+		},
+		"location": mmdbtype.Map{
+			"accuracy_radius": mmdbtype.Uint16(100),
+			"latitude":        mmdbtype.Float64(p.lat),
+			"longitude":       mmdbtype.Float64(p.lon),
+			"time_zone":       mmdbtype.String(p.timeZone),
+		},
+	}
+	if p.city != nil {
+		r["city"] = mmdbtype.Map{"names": names(p.city)}
+	}
+	if p.sub != nil {
+		r["subdivisions"] = mmdbtype.Slice{mmdbtype.Map{
+			"iso_code": mmdbtype.String(p.subISO),
+			"names":    names(p.sub),
+		}}
+	}
+	if p.postal != "" {
+		r["postal"] = mmdbtype.Map{"code": mmdbtype.String(p.postal)}
+	}
+	return r
+}
+
+func main() {
+	out := flag.String("o", "GeoIP2-City-Test.mmdb", "output file")
+	flag.Parse()
+
+	if err := write(*out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func write(name string) error {
+	w, err := mmdbwriter.New(mmdbwriter.Options{
+		BuildEpoch:   buildEpoch,
+		DatabaseType: "GeoIP2-City",
+		Description:  map[string]string{"en": "iplookupdb test database"},
+		Languages:    []string{"de", "en", "ja"},
+		RecordSize:   28,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range places {
+		_, network, err := net.ParseCIDR(p.network)
+		if err != nil {
+			return err
+		}
+		if err := w.Insert(network, p.record()); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
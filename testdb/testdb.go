@@ -0,0 +1,36 @@
+// Copyright 2024 Bill Nixon. All rights reserved.
+// Use of this source code is governed by the license found in the LICENSE file.
+
+// Package testdb provides a tiny embedded GeoIP2 City database so tests do
+// not depend on downloading GeoLite data.
+//
+// The database contains the addresses documented in the MaxMind test
+// databases, such as 81.2.69.142 (London, GB), 89.160.20.112 (Linköping, SE),
+// 175.16.199.0 (Changchun, CN), 216.160.83.56 (Milton, US), and 2001:218::
+// (JP), along with 8.8.8.8 and 208.67.222.222 (US). Names are available in
+// English and for some records in German and Japanese.
+package testdb
+
+import (
+	_ "embed"
+
+	"github.com/bnixon67/iplookupdb/lookup"
+)
+
+//go:generate go run ./internal/gen -o GeoIP2-City-Test.mmdb
+
+//go:embed GeoIP2-City-Test.mmdb
+var cityDB []byte
+
+// Bytes returns a copy of the test database.
+func Bytes() []byte {
+	return append([]byte(nil), cityDB...)
+}
+
+// Open returns a Looker backed by the test database, looking up names in
+// English. The Looker should be closed when no longer needed, although
+// closing it does not release any resources. Use its DB method for the
+// underlying Reader.
+func Open() (*lookup.Looker, error) {
+	return lookup.FromBytes(cityDB)
+}